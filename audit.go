@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// auditEntry is a single line of the audit log.
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Target    string    `json:"target"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	User      string    `json:"user,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	PID       int       `json:"pid"`
+	Version   string    `json:"version"`
+	RunID     string    `json:"run_id"`
+}
+
+var (
+	auditMu   sync.Mutex
+	auditFile *os.File
+	auditUser string
+	auditHost string
+)
+
+// openAuditLog opens the audit log for appending. Until it is called, audit
+// is a no-op.
+func openAuditLog(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to open audit log %q: %s", path, err)
+	}
+	auditFile = f
+
+	if u, err := user.Current(); err == nil {
+		auditUser = u.Username
+	}
+	auditHost, _ = os.Hostname()
+	return nil
+}
+
+// audit records the outcome of an operation on the target resource.
+func audit(operation, target string, err error) {
+	if auditFile == nil {
+		return
+	}
+
+	entry := auditEntry{
+		Time:      time.Now().UTC(),
+		Operation: operation,
+		Target:    target,
+		Outcome:   "success",
+		User:      auditUser,
+		Host:      auditHost,
+		PID:       os.Getpid(),
+		Version:   Revision,
+		RunID:     runID,
+	}
+	if err != nil {
+		entry.Outcome = "failure"
+		entry.Error = err.Error()
+	}
+
+	line, _ := json.Marshal(entry)
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if _, err := auditFile.Write(append(line, '\n')); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to write audit log: %s\n", os.Args[0], err)
+	}
+}
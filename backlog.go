@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pubsubapi "cloud.google.com/go/pubsub/apiv1"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+)
+
+// backlogLease is how long, in seconds, pulled messages are held while the
+// rest of a backlog is pulled, so that none is redelivered and seen twice.
+const backlogLease = 600
+
+// sampleLease is the lease metrics-exporter holds messages for instead, which
+// it pulls periodically from subscriptions consumers are reading from. Pulling
+// a backlog can outlast it, but messages redelivered meanwhile are only
+// counted once.
+const sampleLease = 10
+
+// pullBacklog pulls from the subscription with the full name until it is
+// empty or limit distinct messages have been pulled, calling fn with each of
+// them. The messages are held for lease seconds rather than redelivered, so
+// the caller must acknowledge or release every one of the returned ack IDs.
+func pullBacklog(ctx context.Context, client *pubsubapi.SubscriberClient, name string, limit int, lease int32, fn func(*pubsubpb.ReceivedMessage)) ([]string, error) {
+	var ackIDs []string
+	seen := make(map[string]bool)
+	for len(seen) < limit {
+		pullCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		resp, err := client.Pull(pullCtx, &pubsubpb.PullRequest{
+			Subscription:      name,
+			MaxMessages:       int32(min(limit-len(seen), 1000)),
+			ReturnImmediately: true,
+		})
+		cancel()
+		if errors.Is(pullCtx.Err(), context.DeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			return ackIDs, err
+		}
+		if len(resp.GetReceivedMessages()) == 0 {
+			break
+		}
+
+		var batch []string
+		for _, m := range resp.GetReceivedMessages() {
+			batch = append(batch, m.GetAckId())
+		}
+		ackIDs = append(ackIDs, batch...)
+		if err := modifyAckDeadline(ctx, client, name, batch, lease); err != nil {
+			return ackIDs, err
+		}
+		for _, m := range resp.GetReceivedMessages() {
+			if !seen[m.GetMessage().GetMessageId()] {
+				seen[m.GetMessage().GetMessageId()] = true
+				fn(m)
+			}
+		}
+	}
+	return ackIDs, nil
+}
+
+// newPushMessage returns the message in the format push endpoints receive.
+func newPushMessage(m *pubsubpb.PubsubMessage) pushMessage {
+	return pushMessage{
+		Data:        m.GetData(),
+		Attributes:  m.GetAttributes(),
+		MessageID:   m.GetMessageId(),
+		PublishTime: m.GetPublishTime().AsTime(),
+		OrderingKey: m.GetOrderingKey(),
+	}
+}
+
+// releaseMessages makes the messages with the ack IDs available for
+// redelivery straight away.
+func releaseMessages(ctx context.Context, client *pubsubapi.SubscriberClient, name string, ackIDs []string) error {
+	return modifyAckDeadline(ctx, client, name, ackIDs, 0)
+}
+
+// acknowledgeMessages acknowledges the messages with the ack IDs, in batches
+// the API accepts.
+func acknowledgeMessages(ctx context.Context, client *pubsubapi.SubscriberClient, name string, ackIDs []string) error {
+	for len(ackIDs) > 0 {
+		batch := ackIDs[:min(len(ackIDs), 1000)]
+		ackIDs = ackIDs[len(batch):]
+		if err := client.Acknowledge(ctx, &pubsubpb.AcknowledgeRequest{Subscription: name, AckIds: batch}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modifyAckDeadline sets the ack deadline of the messages with the ack IDs,
+// in batches the API accepts.
+func modifyAckDeadline(ctx context.Context, client *pubsubapi.SubscriberClient, name string, ackIDs []string, seconds int32) error {
+	for len(ackIDs) > 0 {
+		batch := ackIDs[:min(len(ackIDs), 1000)]
+		ackIDs = ackIDs[len(batch):]
+		err := client.ModifyAckDeadline(ctx, &pubsubpb.ModifyAckDeadlineRequest{
+			Subscription:       name,
+			AckIds:             batch,
+			AckDeadlineSeconds: seconds,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
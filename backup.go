@@ -0,0 +1,534 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	pubsubapi "cloud.google.com/go/pubsub/apiv1"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/api/iterator"
+	"gopkg.in/yaml.v3"
+)
+
+// topologyFile is the archive member holding the backed up topology, as a
+// config file.
+const topologyFile = "topology.yaml"
+
+// messagesFile returns the archive member holding the pending messages of a
+// subscription, one JSON object per line in the format push endpoints
+// receive.
+func messagesFile(projectID, subscriptionID string) string {
+	return path.Join("messages", projectID, subscriptionID+".jsonl")
+}
+
+// backupCommand writes the topics and subscriptions of the declared projects,
+// and the pending messages of their pull subscriptions, to an archive that
+// restore can rebuild them from.
+func backupCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	var output string
+	flags.StringVar(&output, "output", "", "Archive to write: .tar.gz, .tgz or .tar.zst for a compressed one, .tar, or - for a tar stream on stdout")
+	flags.StringVar(&output, "o", "", "Shorthand for --output")
+	limit := flags.Int("limit", 100000, "Most pending messages to back up per subscription")
+	flags.Parse(args)
+
+	if output == "" {
+		return fmt.Errorf("backup: --output is required")
+	}
+	// An archive holds each project once, so it can't have several copies.
+	if len(emulatorHosts) > 1 {
+		return fmt.Errorf("backup: Expected at most one --emulator-host to back up")
+	}
+	compression, err := archiveCompression(output)
+	if err != nil {
+		return fmt.Errorf("backup: %s", err)
+	}
+
+	config, err := loadConfig(*configFile, *projectsFile, nameData(*branch, *buildID, *nameUser, runID))
+	if err != nil {
+		return err
+	}
+	if len(config.Projects) == 0 {
+		return fmt.Errorf("backup: Expected PUBSUB_PROJECT variables or a config file declaring the projects to back up")
+	}
+
+	// The archive takes stdout over from the log, as with the event stream.
+	// Everything written is closed in reverse order, as closing flushes the
+	// final blocks of the archive.
+	var w io.Writer = os.Stdout
+	var closers []io.Closer
+	if output == "-" {
+		logOutput = os.Stderr
+	} else {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("Unable to create archive %q: %s", output, err)
+		}
+		defer f.Close()
+		w = f
+		closers = append(closers, f)
+	}
+	switch compression {
+	case "gzip":
+		zw := gzip.NewWriter(w)
+		defer zw.Close()
+		w = zw
+		closers = append(closers, zw)
+	case "zstd":
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return fmt.Errorf("Unable to create archive %q: %s", output, err)
+		}
+		defer zw.Close()
+		w = zw
+		closers = append(closers, zw)
+	}
+	archive := tar.NewWriter(w)
+	defer archive.Close()
+	closers = append(closers, archive)
+	add := func(name string, data []byte) error {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+		if err := archive.WriteHeader(header); err != nil {
+			return fmt.Errorf("Unable to write %s to archive %q: %s", name, output, err)
+		}
+		if _, err := archive.Write(data); err != nil {
+			return fmt.Errorf("Unable to write %s to archive %q: %s", name, output, err)
+		}
+		return nil
+	}
+
+	topology := &Config{}
+	for _, declared := range config.Projects {
+		project, err := backupProject(ctx, declared, *limit, add)
+		if err != nil {
+			return err
+		}
+		topology.Projects = append(topology.Projects, project)
+	}
+
+	var b bytes.Buffer
+	if err := writeYAML(&b, topology); err != nil {
+		return err
+	}
+	if err := add(topologyFile, b.Bytes()); err != nil {
+		return err
+	}
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil {
+			return fmt.Errorf("Unable to write archive %q: %s", output, err)
+		}
+	}
+	if output != "-" {
+		fmt.Fprintf(logOutput, "Backed up %d projects to %s\n", len(topology.Projects), output)
+	}
+	return nil
+}
+
+// archiveCompression returns the compression of the archive at name, gzip,
+// zstd or none, going by its extension.
+func archiveCompression(name string) (string, error) {
+	switch {
+	case name == "-" || strings.HasSuffix(name, ".tar"):
+		return "", nil
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		return "gzip", nil
+	case strings.HasSuffix(name, ".tar.zst") || strings.HasSuffix(name, ".tzst"):
+		return "zstd", nil
+	default:
+		return "", fmt.Errorf("Unknown archive type %q: expected .tar.gz, .tgz, .tar.zst, .tzst, .tar or -", name)
+	}
+}
+
+// backupProject reads the topics and subscriptions of the project as it is on
+// the emulator, passing the pending messages of each pull subscription to add.
+func backupProject(ctx context.Context, declared *Project, limit int, add func(name string, data []byte) error) (*Project, error) {
+	projectID := declared.ID
+	client, err := newClient(ctx, projectID, declared.EmulatorHost)
+	if err != nil {
+		return nil, apiErrorf(err, "Unable to create client to project %q", projectID)
+	}
+	defer client.Close()
+	opts, err := clientOptions(ctx, declared.EmulatorHost)
+	if err != nil {
+		return nil, apiErrorf(err, "Unable to create client to project %q", projectID)
+	}
+	subscriber, err := pubsubapi.NewSubscriberClient(ctx, opts...)
+	if err != nil {
+		return nil, apiErrorf(err, "Unable to create client to project %q", projectID)
+	}
+	defer subscriber.Close()
+
+	// Topics sharing a schema read it once.
+	var schemaClient *pubsub.SchemaClient
+	defer func() {
+		if schemaClient != nil {
+			schemaClient.Close()
+		}
+	}()
+	schemas := make(map[string]*TopicSchema)
+
+	debugf("Backing up project %q", projectID)
+	project := &Project{ID: projectID, EmulatorHost: declared.EmulatorHost}
+	topics := make(map[string]*Topic)
+	topicIterator := client.Topics(ctx)
+	for {
+		topic, err := topicIterator.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, apiErrorf(err, "Unable to list topics for project %q", projectID)
+		}
+		config, err := topic.Config(ctx)
+		if err != nil {
+			return nil, apiErrorf(err, "Unable to get topic %q for project %q", topic.ID(), projectID)
+		}
+		t := &Topic{ID: topic.ID()}
+		delete(config.Labels, runIDLabel)
+		if len(config.Labels) > 0 {
+			t.Labels = config.Labels
+		}
+		if settings := config.SchemaSettings; settings != nil && settings.Schema != "" {
+			if settings.Schema == deletedSchema {
+				fmt.Fprintf(logOutput, "Warning: the schema of topic %q was deleted, so it is backed up without one\n", topic.ID())
+			} else {
+				schema, ok := schemas[settings.Schema]
+				if !ok {
+					if schemaClient == nil {
+						schemaClient, err = newSchemaClient(ctx, projectID, declared.EmulatorHost)
+						if err != nil {
+							return nil, apiErrorf(err, "Unable to create schema client to project %q", projectID)
+						}
+					}
+					if schema, err = backupSchema(ctx, schemaClient, projectID, settings.Schema); err != nil {
+						return nil, err
+					}
+					schemas[settings.Schema] = schema
+				}
+				copied := *schema
+				if settings.Encoding == pubsub.EncodingJSON {
+					copied.Encoding = "json"
+				}
+				t.Schema = &copied
+			}
+		}
+		topics[topic.String()] = t
+		project.Topics = append(project.Topics, t)
+	}
+
+	subscriptions := client.Subscriptions(ctx)
+	for {
+		subscription, err := subscriptions.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, apiErrorf(err, "Unable to list subscriptions for project %q", projectID)
+		}
+		config, err := subscription.Config(ctx)
+		if err != nil {
+			return nil, apiErrorf(err, "Unable to get subscription %q for project %q", subscription.ID(), projectID)
+		}
+		topic := topics[config.Topic.String()]
+		if topic == nil {
+			debugf("  Skipping subscription %q of topic %q outside the project", subscription.ID(), config.Topic.String())
+			continue
+		}
+
+		s := backupSubscription(subscription.ID(), config)
+		topic.Subscriptions = append(topic.Subscriptions, s)
+		if s.PushEndpoint != "" {
+			continue
+		}
+
+		var messages bytes.Buffer
+		encoder := json.NewEncoder(&messages)
+		name := subscriptionName(projectID, subscription.ID())
+		count := 0
+		ordered := false
+		held, err := pullBacklog(ctx, subscriber, name, limit, backlogLease, func(m *pubsubpb.ReceivedMessage) {
+			count++
+			message := m.GetMessage()
+			ordered = ordered || (config.EnableMessageOrdering && message.GetOrderingKey() != "")
+			encoder.Encode(newPushMessage(message))
+		})
+		if releaseErr := releaseMessages(ctx, subscriber, name, held); err == nil {
+			err = releaseErr
+		}
+		if err != nil {
+			return nil, apiErrorf(err, "Unable to back up the messages of subscription %q for project %q", subscription.ID(), projectID)
+		}
+		debugf("  Backed up %d messages of subscription %q", count, subscription.ID())
+		if ordered {
+			fmt.Fprintf(logOutput, "Warning: only the first pending message of each ordering key of subscription %q is backed up\n", subscription.ID())
+		}
+		if count > 0 {
+			if err := add(messagesFile(projectID, subscription.ID()), messages.Bytes()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return project, nil
+}
+
+// deletedSchema is the schema name of topics whose schema was deleted.
+const deletedSchema = "_deleted-schema_"
+
+// backupSchema reads the schema with the full name, which must be in the
+// project, as a topic schema that restore can create again.
+func backupSchema(ctx context.Context, client *pubsub.SchemaClient, projectID, name string) (*TopicSchema, error) {
+	schemaProject, schemaID, ok := splitSchemaName(name)
+	if !ok || schemaProject != projectID {
+		return nil, fmt.Errorf("backup: Schema %q is outside project %q, so it can't be backed up with its topics", name, projectID)
+	}
+	return readTopicSchema(ctx, client, projectID, schemaID)
+}
+
+// backupSubscription describes the subscription with the given configuration
+// as the config file would.
+func backupSubscription(id string, config pubsub.SubscriptionConfig) *Subscription {
+	s := &Subscription{ID: id, PushEndpoint: config.PushConfig.Endpoint}
+	if oidc, ok := config.PushConfig.AuthenticationMethod.(*pubsub.OIDCToken); ok {
+		s.PushAuth = &PushAuth{ServiceAccount: oidc.ServiceAccountEmail, Audience: oidc.Audience}
+	}
+	delete(config.Labels, runIDLabel)
+	if len(config.Labels) > 0 {
+		s.Labels = config.Labels
+	}
+	if config.AckDeadline > 0 {
+		s.AckDeadline = &config.AckDeadline
+	}
+	if config.RetentionDuration > 0 {
+		s.Retention = &config.RetentionDuration
+	}
+	if config.RetryPolicy != nil {
+		s.RetryPolicy = &RetryPolicy{}
+		if d, ok := config.RetryPolicy.MinimumBackoff.(time.Duration); ok {
+			s.RetryPolicy.MinimumBackoff = &d
+		}
+		if d, ok := config.RetryPolicy.MaximumBackoff.(time.Duration); ok {
+			s.RetryPolicy.MaximumBackoff = &d
+		}
+	}
+	if config.EnableMessageOrdering {
+		s.MessageOrdering = boolPtr(true)
+	}
+	if config.DeadLetterPolicy != nil {
+		s.DeadLetterTopic = config.DeadLetterPolicy.DeadLetterTopic
+		maxAttempts := config.DeadLetterPolicy.MaxDeliveryAttempts
+		s.DeadLetterMaxAttempts = &maxAttempts
+	}
+	return s
+}
+
+// restoreCommand rebuilds the topics and subscriptions in an archive written
+// by backup, and then republishes the pending messages of its pull
+// subscriptions.
+func restoreCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("restore: Expected the archive to restore, or - to read a tar stream from stdin")
+	}
+	name := flags.Arg(0)
+
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("Unable to open archive %q: %s", name, err)
+		}
+		defer f.Close()
+		r = f
+	}
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("Unable to read archive %q: %s", name, err)
+		}
+		r = zr
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("Unable to read archive %q: %s", name, err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		r = br
+	}
+
+	var topology *Config
+	messages := make(map[string][]pushMessage)
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to read archive %q: %s", name, err)
+		}
+		data, err := io.ReadAll(archive)
+		if err != nil {
+			return fmt.Errorf("Unable to read %s from archive %q: %s", header.Name, name, err)
+		}
+
+		if header.Name == topologyFile {
+			topology = &Config{}
+			if err := yaml.Unmarshal(data, topology); err != nil {
+				return ErrConfigSyntax.wrapf("Unable to parse %s in archive %q: %s", topologyFile, name, err)
+			}
+			continue
+		}
+		for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+			var m pushMessage
+			if err := json.Unmarshal(line, &m); err != nil {
+				return fmt.Errorf("Unable to parse message in %s of archive %q: %s", header.Name, name, err)
+			}
+			messages[header.Name] = append(messages[header.Name], m)
+		}
+	}
+	if topology == nil {
+		return fmt.Errorf("restore: Archive %q has no %s", name, topologyFile)
+	}
+	// The emulators the backup was taken from are not where it is restored
+	// to, which is PUBSUB_EMULATOR_HOST, or each --emulator-host.
+	for _, project := range topology.Projects {
+		project.EmulatorHost = ""
+	}
+	if err := topology.validate(); err != nil {
+		return err
+	}
+	if len(emulatorHosts) > 0 {
+		if err := topology.fanOut(emulatorHosts); err != nil {
+			return err
+		}
+	}
+
+	if err := create(ctx, topology.Projects); err != nil {
+		return err
+	}
+	for _, project := range topology.Projects {
+		if err := restoreMessages(ctx, project, messages); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(logOutput, "Restored %d projects from %s\n", len(topology.Projects), name)
+	return nil
+}
+
+// restoreMessages republishes the backed up messages of every topic in the
+// project once, and then acknowledges them again in each pull subscription
+// that had already acknowledged them, so that every subscription has the
+// backlog it had. Push subscriptions receive every republished message, and
+// ordered subscriptions keep those queued behind a pending message with the
+// same ordering key, as they can't be pulled to be acknowledged.
+func restoreMessages(ctx context.Context, project *Project, messages map[string][]pushMessage) error {
+	client, err := newClient(ctx, project.ID, project.EmulatorHost)
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", project.ID)
+	}
+	defer client.Close()
+	opts, err := clientOptions(ctx, project.EmulatorHost)
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", project.ID)
+	}
+	subscriber, err := pubsubapi.NewSubscriberClient(ctx, opts...)
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", project.ID)
+	}
+	defer subscriber.Close()
+
+	for _, t := range project.Topics {
+		// Collect the pending messages of every subscription, by their ID at
+		// backup time.
+		var union []pushMessage
+		seen := make(map[string]bool)
+		pending := make(map[string]map[string]bool)
+		for _, subscription := range t.Subscriptions {
+			pending[subscription.ID] = make(map[string]bool)
+			for _, m := range messages[messagesFile(project.ID, subscription.ID)] {
+				if !seen[m.MessageID] {
+					seen[m.MessageID] = true
+					union = append(union, m)
+				}
+				pending[subscription.ID][m.MessageID] = true
+			}
+		}
+		if len(union) == 0 {
+			continue
+		}
+		sort.SliceStable(union, func(i, j int) bool { return union[i].PublishTime.Before(union[j].PublishTime) })
+
+		debugf("  Republishing %d messages to topic %q", len(union), t.ID)
+		topic := client.Topic(t.ID)
+		topic.EnableMessageOrdering = true
+		results := make([]*pubsub.PublishResult, len(union))
+		for i, m := range union {
+			results[i] = topic.Publish(ctx, &pubsub.Message{Data: m.Data, Attributes: m.Attributes, OrderingKey: m.OrderingKey})
+		}
+		original := make(map[string]string)
+		for i, result := range results {
+			id, err := result.Get(ctx)
+			if err != nil {
+				topic.Stop()
+				return apiErrorf(err, "Unable to republish message %q to topic %q for project %q", union[i].MessageID, t.ID, project.ID)
+			}
+			original[id] = union[i].MessageID
+		}
+		topic.Stop()
+
+		for _, subscription := range t.Subscriptions {
+			if subscription.PushEndpoint != "" {
+				continue
+			}
+			name := subscriptionName(project.ID, subscription.ID)
+			acked := make(map[string]bool)
+			held, err := pullBacklog(ctx, subscriber, name, len(union), backlogLease, func(m *pubsubpb.ReceivedMessage) {
+				id, ok := original[m.GetMessage().GetMessageId()]
+				if ok && !pending[subscription.ID][id] {
+					acked[m.GetAckId()] = true
+				}
+			})
+			var release, ack []string
+			for _, ackID := range held {
+				if acked[ackID] {
+					ack = append(ack, ackID)
+				} else {
+					release = append(release, ackID)
+				}
+			}
+			if err == nil {
+				err = acknowledgeMessages(ctx, subscriber, name, ack)
+			}
+			if releaseErr := releaseMessages(ctx, subscriber, name, release); err == nil {
+				err = releaseErr
+			}
+			if err != nil {
+				return apiErrorf(err, "Unable to restore the messages of subscription %q for project %q", subscription.ID, project.ID)
+			}
+			debugf("    Subscription %q has %d of them", subscription.ID, len(pending[subscription.ID]))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// Attributes set on every benchmark message so that the receiver can work out
+// the delivery latency and ignore messages left over from other runs, which
+// have another run ID.
+const (
+	benchRunAttribute    = "pubsubc-bench-run"
+	benchSentAtAttribute = "pubsubc-bench-sent-at"
+)
+
+// benchCommand runs one of the benchmark subcommands.
+func benchCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Expected a benchmark to run: roundtrip or soak")
+	}
+
+	switch args[0] {
+	case "roundtrip":
+		return benchRoundtrip(ctx, args[1:])
+	case "soak":
+		return benchSoak(ctx, args[1:])
+	default:
+		return fmt.Errorf("Unknown benchmark %q", args[0])
+	}
+}
+
+// latencies collects latency samples from concurrent goroutines.
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	l.samples = append(l.samples, d)
+	l.mu.Unlock()
+}
+
+// percentile returns the p-th percentile (0-100) of the collected samples.
+func (l *latencies) percentile(p float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.samples) == 0 {
+		return 0
+	}
+	sort.Slice(l.samples, func(i, j int) bool { return l.samples[i] < l.samples[j] })
+	i := int(float64(len(l.samples)-1) * p / 100)
+	return l.samples[i]
+}
+
+func (l *latencies) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.samples)
+}
+
+// summary formats the usual percentiles for printing.
+func (l *latencies) summary() string {
+	return fmt.Sprintf("p50=%s p90=%s p95=%s p99=%s max=%s", l.percentile(50), l.percentile(90), l.percentile(95), l.percentile(99), l.percentile(100))
+}
+
+// latencyReport is the JSON report of a set of latencies, in milliseconds.
+type latencyReport struct {
+	Count     int               `json:"count"`
+	P50       float64           `json:"p50_ms"`
+	P90       float64           `json:"p90_ms"`
+	P95       float64           `json:"p95_ms"`
+	P99       float64           `json:"p99_ms"`
+	Max       float64           `json:"max_ms"`
+	Histogram []histogramBucket `json:"histogram"`
+}
+
+// histogramBucket counts the latencies above the previous bucket's bound and
+// up to its own.
+type histogramBucket struct {
+	UpperBound float64 `json:"le_ms"`
+	Count      int     `json:"count"`
+}
+
+// milliseconds returns d in fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// report returns the percentiles of the samples with a histogram whose bucket
+// bounds double from 100µs, so that it stays small however spread out they are.
+func (l *latencies) report() latencyReport {
+	r := latencyReport{
+		Count: l.count(),
+		P50:   milliseconds(l.percentile(50)),
+		P90:   milliseconds(l.percentile(90)),
+		P95:   milliseconds(l.percentile(95)),
+		P99:   milliseconds(l.percentile(99)),
+		Max:   milliseconds(l.percentile(100)),
+
+		Histogram: []histogramBucket{},
+	}
+
+	// percentile left the samples sorted.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bound := 100 * time.Microsecond
+	bucket := histogramBucket{UpperBound: milliseconds(bound)}
+	for _, sample := range l.samples {
+		for sample > bound {
+			r.Histogram = append(r.Histogram, bucket)
+			bound *= 2
+			bucket = histogramBucket{UpperBound: milliseconds(bound)}
+		}
+		bucket.Count++
+	}
+	if bucket.Count > 0 {
+		r.Histogram = append(r.Histogram, bucket)
+	}
+	return r
+}
+
+// benchReport is the JSON report of a benchmark.
+type benchReport struct {
+	Duration  float64       `json:"duration_ms"`
+	Published int64         `json:"published"`
+	Failed    int64         `json:"failed"`
+	Received  int           `json:"received"`
+	Publish   latencyReport `json:"publish"`
+	EndToEnd  latencyReport `json:"end_to_end"`
+}
+
+// writeReport writes the report to path as indented JSON.
+func writeReport(path string, report interface{}) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Unable to encode report: %s", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("Unable to write report %q: %s", path, err)
+	}
+	return nil
+}
+
+// benchRoundtrip publishes messages to a topic for a fixed duration while
+// receiving them from a subscription, then reports publish latency, end to end
+// delivery latency and throughput.
+func benchRoundtrip(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("bench roundtrip", flag.ExitOnError)
+	projectID := flags.String("project", defaultProjectID(), "Project ID containing the topic and subscription")
+	topicID := flags.String("topic", "", "Topic to publish to")
+	subscriptionID := flags.String("subscription", "", "Subscription on the topic to receive from")
+	duration := flags.Duration("duration", 60*time.Second, "How long to publish for")
+	drain := flags.Duration("drain", 5*time.Second, "How long to keep receiving after publishing stops")
+	size := flags.Int("size", 1024, "Message payload size in bytes")
+	inflight := flags.Int("inflight", 100, "Maximum number of unconfirmed publishes")
+	reportFile := flags.String("report", "", "Write the results, with latency histograms, to this file as JSON")
+	publishSettings := addPublishFlags(flags)
+	receiveSettings := addReceiveFlags(flags)
+	flags.Parse(args)
+
+	if *projectID == "" || *topicID == "" || *subscriptionID == "" {
+		return fmt.Errorf("bench roundtrip: --project, --topic and --subscription are required")
+	}
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", *projectID)
+	}
+	defer client.Close()
+
+	payload := make([]byte, *size)
+
+	var publishLatency, deliveryLatency latencies
+	var published, failed int64
+
+	// Receive until publishing has finished and the drain period has passed.
+	receiveCtx, stopReceiving := context.WithCancel(ctx)
+	defer stopReceiving()
+	receiveErr := make(chan error, 1)
+	subscription := client.Subscription(*subscriptionID)
+	receiveSettings.apply(subscription)
+	go func() {
+		receiveErr <- subscription.Receive(receiveCtx, func(_ context.Context, m *pubsub.Message) {
+			m.Ack()
+			if m.Attributes[benchRunAttribute] != runID {
+				return
+			}
+			sentAt, err := strconv.ParseInt(m.Attributes[benchSentAtAttribute], 10, 64)
+			if err != nil {
+				return
+			}
+			deliveryLatency.add(time.Since(time.Unix(0, sentAt)))
+		})
+	}()
+
+	debugf("Benchmarking topic %q and subscription %q on project %q for %s", *topicID, *subscriptionID, *projectID, *duration)
+
+	topic := client.Topic(*topicID)
+	publishSettings.apply(topic)
+	defer topic.Stop()
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, *inflight)
+	start := time.Now()
+	deadline := start.Add(*duration)
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		slots <- struct{}{}
+		sentAt := time.Now()
+		result := topic.Publish(ctx, &pubsub.Message{
+			Data: payload,
+			Attributes: map[string]string{
+				benchRunAttribute:    runID,
+				benchSentAtAttribute: strconv.FormatInt(sentAt.UnixNano(), 10),
+			},
+		})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if _, err := result.Get(ctx); err != nil {
+				atomic.AddInt64(&failed, 1)
+				debugf("  Publish failed: %s", err)
+				return
+			}
+			atomic.AddInt64(&published, 1)
+			publishLatency.add(time.Since(sentAt))
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	debugf("  Publishing finished, draining for %s", *drain)
+	select {
+	case <-time.After(*drain):
+	case <-ctx.Done():
+	}
+	stopReceiving()
+	if err := <-receiveErr; err != nil && !errors.Is(err, context.Canceled) {
+		return apiErrorf(err, "Unable to receive from subscription %q for project %q", *subscriptionID, *projectID)
+	}
+
+	received := deliveryLatency.count()
+	fmt.Printf("duration:   %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("published:  %d (%d failed, %.1f msg/s)\n", published, failed, float64(published)/elapsed.Seconds())
+	fmt.Printf("received:   %d (%.1f msg/s)\n", received, float64(received)/elapsed.Seconds())
+	fmt.Printf("publish:    %s\n", publishLatency.summary())
+	fmt.Printf("end-to-end: %s\n", deliveryLatency.summary())
+
+	if *reportFile != "" {
+		return writeReport(*reportFile, benchReport{
+			Duration:  milliseconds(elapsed),
+			Published: published,
+			Failed:    failed,
+			Received:  received,
+			Publish:   publishLatency.report(),
+			EndToEnd:  deliveryLatency.report(),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configHash returns a hash of the resolved config and the emulator it is
+// created on.
+func configHash(config *Config) (string, error) {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("Unable to hash config: %s", err)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", os.Getenv("PUBSUB_EMULATOR_HOST"))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// upToDate reports whether the config was last applied with the hash in the
+// cache file and everything it declares still exists, as it won't after an
+// emulator restart.
+func upToDate(ctx context.Context, config *Config, cacheFile, hash string) (bool, error) {
+	cached, err := os.ReadFile(cacheFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Unable to read cache file %q: %s", cacheFile, err)
+	}
+	fields := strings.Fields(string(cached))
+	if len(fields) == 0 || fields[0] != hash {
+		debugf("Config has changed since it was last applied")
+		return false, nil
+	}
+
+	for _, project := range config.Projects {
+		existing, err := existingResources(ctx, project)
+		if err != nil {
+			return false, err
+		}
+		topicIDs, subscriptionIDs := declaredResources(project)
+		if len(existing) != len(topicIDs)+len(subscriptionIDs) {
+			debugf("Project %q is missing resources", project.ID)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// writeCache records the hash of the applied config, followed by the ID of the
+// run that applied it.
+func writeCache(cacheFile, hash string) error {
+	if err := os.WriteFile(cacheFile, []byte(hash+" "+runID+"\n"), 0644); err != nil {
+		return fmt.Errorf("Unable to write cache file %q: %s", cacheFile, err)
+	}
+	return nil
+}
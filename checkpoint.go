@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkpoint records how far through its input a publish has got: the last
+// line such that it and every line before it have been confirmed published.
+type checkpoint struct {
+	path  string
+	start int
+
+	mu      sync.Mutex
+	line    int
+	written int
+	pending []int
+	done    map[int]bool
+}
+
+// loadCheckpoint returns the checkpoint in path, starting from the beginning
+// if resume is false or it doesn't exist yet.
+func loadCheckpoint(path string, resume bool) (*checkpoint, error) {
+	c := &checkpoint{path: path, done: make(map[int]bool)}
+	if !resume {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read checkpoint %q: %s", path, err)
+	}
+	line, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, ErrConfigSyntax.wrapf("Unable to parse checkpoint %q: %s", path, err)
+	}
+	c.start, c.line, c.written = line, line, line
+	return c, nil
+}
+
+// skip reports whether line was published before the checkpoint was loaded.
+func (c *checkpoint) skip(line int) bool {
+	return line <= c.start
+}
+
+// publishing records that line is being published. Lines must be recorded in
+// order.
+func (c *checkpoint) publishing(line int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, line)
+}
+
+// published records that line has been confirmed published, moving the
+// checkpoint past every line up to the first one still unconfirmed.
+func (c *checkpoint) published(line int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done[line] = true
+	for len(c.pending) > 0 && c.done[c.pending[0]] {
+		c.line = c.pending[0]
+		delete(c.done, c.pending[0])
+		c.pending = c.pending[1:]
+	}
+}
+
+// write saves the checkpoint if it has moved since it was last saved. The
+// file is replaced in one step, so an interrupted write leaves the previous
+// checkpoint.
+func (c *checkpoint) write() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.line == c.written {
+		return nil
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(c.line)+"\n"), 0644); err != nil {
+		return fmt.Errorf("Unable to write checkpoint %q: %s", c.path, err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("Unable to write checkpoint %q: %s", c.path, err)
+	}
+	c.written = c.line
+	return nil
+}
+
+// writeEvery saves the checkpoint every interval until stop is closed.
+func (c *checkpoint) writeEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.write(); err != nil {
+				debugf("  %s", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes the projects, topics and subscriptions to create.
+type Config struct {
+	// Defaults apply to every subscription that doesn't set them itself.
+	Defaults SubscriptionSettings `yaml:"defaults,omitempty"`
+	Projects []*Project           `yaml:"projects"`
+}
+
+// Project describes a PubSub project and its topics.
+type Project struct {
+	ID string `yaml:"id"`
+	// EmulatorHost overrides PUBSUB_EMULATOR_HOST for this project.
+	EmulatorHost string `yaml:"emulator_host,omitempty"`
+	// Count makes the project a template for that many projects, with
+	// {{.Index}} in names running from Start, or 1 if it isn't set.
+	Count  int      `yaml:"count,omitempty"`
+	Start  *int     `yaml:"start,omitempty"`
+	Topics []*Topic `yaml:"topics"`
+}
+
+// Topic describes a PubSub topic and its subscriptions.
+type Topic struct {
+	ID   string   `yaml:"id"`
+	Tags []string `yaml:"tags,omitempty"`
+	// Schema is created in the project, if it doesn't exist, and validates
+	// the messages published to the topic.
+	Schema *TopicSchema `yaml:"schema,omitempty"`
+	// Labels are set on the topic, along with the run ID label.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Defaults apply to every subscription of the topic that doesn't set them
+	// itself, taking precedence over the config defaults.
+	Defaults      SubscriptionSettings `yaml:"defaults,omitempty"`
+	Subscriptions []*Subscription      `yaml:"subscriptions,omitempty"`
+}
+
+// TopicSchema describes the schema of a topic. Topics of the same project can
+// share a schema by giving the same ID and definition.
+type TopicSchema struct {
+	ID string `yaml:"id"`
+	// Type is avro or protobuf.
+	Type       string `yaml:"type"`
+	Definition string `yaml:"definition"`
+	// Encoding of the messages is binary, the default, or json.
+	Encoding string `yaml:"encoding,omitempty"`
+}
+
+// settings returns the settings that attach the schema with the full name to a
+// topic.
+func (s *TopicSchema) settings(name string) *pubsub.SchemaSettings {
+	encoding := schemaEncodings["binary"]
+	if s.Encoding != "" {
+		encoding = schemaEncodings[s.Encoding]
+	}
+	return &pubsub.SchemaSettings{Schema: name, Encoding: encoding}
+}
+
+// Subscription describes a pull subscription, or a push subscription if it
+// has a push endpoint.
+type Subscription struct {
+	ID                   string    `yaml:"id"`
+	Tags                 []string  `yaml:"tags,omitempty"`
+	PushEndpoint         string    `yaml:"push_endpoint,omitempty"`
+	PushAuth             *PushAuth `yaml:"push_auth,omitempty"`
+	SubscriptionSettings `yaml:",inline"`
+}
+
+// PushAuth has push requests carry an OIDC token for a service account, for
+// push endpoints that verify the token's issuer and subject.
+type PushAuth struct {
+	ServiceAccount string `yaml:"service_account"`
+	// Audience defaults to the push endpoint.
+	Audience string `yaml:"audience,omitempty"`
+}
+
+// SubscriptionSettings holds the settings a subscription can inherit. Unset
+// settings are nil, leaving them to be inherited or left at the PubSub default.
+type SubscriptionSettings struct {
+	AckDeadline     *time.Duration    `yaml:"ack_deadline,omitempty"`
+	Retention       *time.Duration    `yaml:"retention,omitempty"`
+	RetryPolicy     *RetryPolicy      `yaml:"retry_policy,omitempty"`
+	Labels          map[string]string `yaml:"labels,omitempty"`
+	MessageOrdering *bool             `yaml:"message_ordering,omitempty"`
+	// DeadLetter forwards undeliverable messages to a "<topic>-dlq" topic,
+	// which gets a "<subscription>-dlq" subscription.
+	DeadLetter            *bool `yaml:"dead_letter,omitempty"`
+	DeadLetterMaxAttempts *int  `yaml:"dead_letter_max_attempts,omitempty"`
+	// DeadLetterTopic forwards undeliverable messages to an existing topic,
+	// given by its full resource name, instead. It can be in another project,
+	// and no dead letter subscription is made for it.
+	DeadLetterTopic string `yaml:"dead_letter_topic,omitempty"`
+}
+
+// RetryPolicy bounds the delay between redeliveries of a message.
+type RetryPolicy struct {
+	MinimumBackoff *time.Duration `yaml:"minimum_backoff,omitempty"`
+	MaximumBackoff *time.Duration `yaml:"maximum_backoff,omitempty"`
+}
+
+// Delimiters separate the parts of a PUBSUB_PROJECT definition.
+type Delimiters struct {
+	Topic        string // Between the project and each topic
+	Subscription string // Between a topic and each subscription
+	Push         string // Between a subscription, its push endpoint and "dlq"
+	Port         string // Between the host and port of a push endpoint
+}
+
+// find returns the first of the delimiters that s contains, or an empty string
+// if it has none.
+func (d Delimiters) find(s string) string {
+	for _, delimiter := range []string{d.Topic, d.Subscription, d.Push, d.Port} {
+		if strings.Contains(s, delimiter) {
+			return delimiter
+		}
+	}
+	return ""
+}
+
+// delimiters are the delimiters in use, set with --delimiters or
+// PUBSUBC_DELIMITERS.
+var delimiters = Delimiters{Topic: ",", Subscription: ":", Push: "+", Port: "|"}
+
+// selectedTags limits the loaded config to the resources with one of these
+// tags, set with --tags. Nothing is left out if it is empty.
+var selectedTags []string
+
+// projectEnvPattern matches a numbered PUBSUB_PROJECT environment variable
+// that is set.
+var projectEnvPattern = regexp.MustCompile(`^PUBSUB_PROJECT([0-9]+)=.`)
+
+// parseDelimiters parses four distinct characters into the topic,
+// subscription, push and port delimiters, in that order.
+func parseDelimiters(s string) (Delimiters, error) {
+	chars := strings.Split(s, "")
+	if len(chars) != 4 {
+		return Delimiters{}, ErrConfigSyntax.wrapf("Invalid delimiters %q: expected 4 characters, such as \",:+|\"", s)
+	}
+	seen := make(map[string]bool)
+	for _, c := range chars {
+		if seen[c] {
+			return Delimiters{}, ErrConfigSyntax.wrapf("Invalid delimiters %q: %q is used more than once", s, c)
+		}
+		seen[c] = true
+	}
+	return Delimiters{Topic: chars[0], Subscription: chars[1], Push: chars[2], Port: chars[3]}, nil
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// loadConfig reads the YAML config file at path, if any, and adds the projects
+// defined in the numbered PUBSUB_PROJECT environment variables and then in the
+// definitions file at projectsPath, if any. Name templates are expanded with
+// names, and every project is copied onto each --emulator-host, if any.
+func loadConfig(path, projectsPath string, names map[string]string) (*Config, error) {
+	config := &Config{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read config file %q: %s", path, err)
+		}
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			if config, err = parseCSVConfig(path, data); err != nil {
+				return nil, err
+			}
+		} else if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, ErrConfigSyntax.wrapf("Unable to parse config file %q: %s", path, err)
+		}
+	}
+
+	// Cycle over the numbered PUBSUB_PROJECT environment variables.
+	for i := 1; ; i++ {
+		// Fetch the enviroment variable. If it doesn't exist, break out.
+		currentEnv := fmt.Sprintf("PUBSUB_PROJECT%d", i)
+		env := os.Getenv(currentEnv)
+		if env == "" {
+			break
+		}
+
+		project, err := parseProject(env)
+		if err != nil {
+			return nil, ErrConfigSyntax.wrapf("%s: %s", currentEnv, err)
+		}
+		project.EmulatorHost = os.Getenv(currentEnv + "_EMULATOR_HOST")
+		config.Projects = append(config.Projects, project)
+	}
+	if err := checkProjectNumbering(); err != nil {
+		return nil, err
+	}
+
+	if projectsPath != "" {
+		projects, err := readProjectsFile(projectsPath)
+		if err != nil {
+			return nil, err
+		}
+		config.Projects = append(config.Projects, projects...)
+	}
+
+	config.applyDefaults()
+	if err := config.expandNames(names); err != nil {
+		return nil, err
+	}
+	config.selectTags(selectedTags)
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if len(emulatorHosts) > 0 {
+		if err := config.fanOut(emulatorHosts); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// checkProjectNumbering fails if a PUBSUB_PROJECT variable is set after a gap
+// in the numbering, which would otherwise be ignored.
+func checkProjectNumbering() error {
+	last := 0
+	for i := 1; os.Getenv(fmt.Sprintf("PUBSUB_PROJECT%d", i)) != ""; i++ {
+		last = i
+	}
+	for _, env := range os.Environ() {
+		match := projectEnvPattern.FindStringSubmatch(env)
+		if match == nil {
+			continue
+		}
+		if n, _ := strconv.Atoi(match[1]); n > last+1 {
+			return ErrConfigSyntax.wrapf("PUBSUB_PROJECT%s is set but PUBSUB_PROJECT%d is not, so it would be ignored", match[1], last+1)
+		}
+	}
+	return nil
+}
+
+// projectDefinitions reads the file at path of project definitions, one per
+// line, along with the line number of each. Blank lines and lines starting
+// with # are skipped.
+func projectDefinitions(path string) ([]string, []int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to read projects file %q: %s", path, err)
+	}
+
+	var definitions []string
+	var lines []int
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		definitions = append(definitions, line)
+		lines = append(lines, i+1)
+	}
+	return definitions, lines, nil
+}
+
+// readProjectsFile parses the project definitions in the file at path, each in
+// the form parseProject accepts.
+func readProjectsFile(path string) ([]*Project, error) {
+	definitions, lines, err := projectDefinitions(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var projects []*Project
+	for i, definition := range definitions {
+		project, err := parseProject(definition)
+		if err != nil {
+			return nil, ErrConfigSyntax.wrapf("%s:%d: %s", path, lines[i], err)
+		}
+		projects = append(projects, project)
+	}
+	return projects, nil
+}
+
+// parseProject parses a project definition of the form
+// "project,topic1,topic2:subscription1,topic3:subscription2+endpoint", using
+// the delimiters in use.
+func parseProject(definition string) (*Project, error) {
+	// Separate the projectID from the topic definitions.
+	parts := strings.Split(definition, delimiters.Topic)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("Expected at least 1 topic to be defined")
+	}
+
+	// Separate the topicID from the subscription IDs.
+	project := &Project{ID: parts[0]}
+	for _, part := range parts[1:] {
+		topicParts := strings.Split(part, delimiters.Subscription)
+		topic := &Topic{ID: topicParts[0]}
+		for _, subscription := range topicParts[1:] {
+			topic.Subscriptions = append(topic.Subscriptions, parseSubscription(subscription))
+		}
+		project.Topics = append(project.Topics, topic)
+	}
+
+	return project, nil
+}
+
+// parseSubscription parses a subscription definition of the form
+// "subscription", "subscription+host|port" or "subscription+host|port+dlq".
+func parseSubscription(definition string) *Subscription {
+	subscriptionParts := strings.Split(definition, delimiters.Push)
+	subscription := &Subscription{ID: subscriptionParts[0]}
+	if len(subscriptionParts) > 1 {
+		subscription.PushEndpoint = "http://" + strings.Replace(subscriptionParts[1], delimiters.Port, ":", 1)
+		subscription.MessageOrdering = boolPtr(true)
+
+		if len(subscriptionParts) == 3 && subscriptionParts[2] == "dlq" {
+			subscription.DeadLetter = boolPtr(true)
+		}
+	}
+
+	return subscription
+}
+
+// fanOut replaces every project with a copy of it on each of the emulator
+// hosts. Projects that set their own emulator host can't be fanned out.
+func (c *Config) fanOut(hosts []string) error {
+	var projects []*Project
+	for _, host := range hosts {
+		for _, project := range c.Projects {
+			if project.EmulatorHost != "" {
+				return ErrConfigInvalid.wrapf("Project %q has its own emulator host %q, which --emulator-host would override", project.ID, project.EmulatorHost)
+			}
+			copied := *project
+			copied.EmulatorHost = host
+			projects = append(projects, &copied)
+		}
+	}
+	c.Projects = projects
+	return nil
+}
+
+// applyDefaults fills in the settings of every subscription from the defaults
+// of its topic, and then from the config defaults.
+func (c *Config) applyDefaults() {
+	for _, project := range c.Projects {
+		for _, topic := range project.Topics {
+			topic.Defaults.inherit(c.Defaults)
+			for _, subscription := range topic.Subscriptions {
+				subscription.inherit(topic.Defaults)
+			}
+		}
+	}
+}
+
+// selectTags removes the topics and subscriptions that have none of the tags.
+// A subscription is kept if it or its topic has one of them, and a topic is
+// kept if it has one or any of its subscriptions are kept.
+func (c *Config) selectTags(tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	for _, project := range c.Projects {
+		var topics []*Topic
+		for _, topic := range project.Topics {
+			if hasTag(topic.Tags, tags) {
+				topics = append(topics, topic)
+				continue
+			}
+			var subscriptions []*Subscription
+			for _, subscription := range topic.Subscriptions {
+				if hasTag(subscription.Tags, tags) {
+					subscriptions = append(subscriptions, subscription)
+				}
+			}
+			if len(subscriptions) > 0 {
+				topic.Subscriptions = subscriptions
+				topics = append(topics, topic)
+			}
+		}
+		project.Topics = topics
+	}
+}
+
+// hasTag reports whether any of the tags are in have.
+func hasTag(have, tags []string) bool {
+	for _, h := range have {
+		for _, tag := range tags {
+			if h == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inherit fills in the settings that s doesn't set from parent. Labels are
+// merged, with the labels of s taking precedence.
+func (s *SubscriptionSettings) inherit(parent SubscriptionSettings) {
+	if s.AckDeadline == nil {
+		s.AckDeadline = parent.AckDeadline
+	}
+	if s.Retention == nil {
+		s.Retention = parent.Retention
+	}
+	if s.RetryPolicy == nil {
+		s.RetryPolicy = parent.RetryPolicy
+	}
+	if s.MessageOrdering == nil {
+		s.MessageOrdering = parent.MessageOrdering
+	}
+	if s.DeadLetter == nil {
+		s.DeadLetter = parent.DeadLetter
+	}
+	if s.DeadLetterMaxAttempts == nil {
+		s.DeadLetterMaxAttempts = parent.DeadLetterMaxAttempts
+	}
+	if s.DeadLetterTopic == "" {
+		s.DeadLetterTopic = parent.DeadLetterTopic
+	}
+
+	if len(parent.Labels) > 0 {
+		labels := make(map[string]string, len(parent.Labels)+len(s.Labels))
+		for k, v := range parent.Labels {
+			labels[k] = v
+		}
+		for k, v := range s.Labels {
+			labels[k] = v
+		}
+		s.Labels = labels
+	}
+}
+
+// config returns the PubSub configuration of the subscription on topic.
+func (s *Subscription) config(topic *pubsub.Topic) pubsub.SubscriptionConfig {
+	config := pubsub.SubscriptionConfig{
+		Topic:      topic,
+		PushConfig: newPushConfig(s.PushEndpoint, s.PushAuth),
+		Labels:     runLabels(s.Labels),
+	}
+	if s.AckDeadline != nil {
+		config.AckDeadline = *s.AckDeadline
+	}
+	if s.Retention != nil {
+		config.RetentionDuration = *s.Retention
+	}
+	if s.RetryPolicy != nil {
+		config.RetryPolicy = &pubsub.RetryPolicy{}
+		if s.RetryPolicy.MinimumBackoff != nil {
+			config.RetryPolicy.MinimumBackoff = *s.RetryPolicy.MinimumBackoff
+		}
+		if s.RetryPolicy.MaximumBackoff != nil {
+			config.RetryPolicy.MaximumBackoff = *s.RetryPolicy.MaximumBackoff
+		}
+	}
+	if s.MessageOrdering != nil {
+		config.EnableMessageOrdering = *s.MessageOrdering
+	}
+
+	return config
+}
+
+// newPushConfig returns the PubSub push configuration for endpoint,
+// authenticated with auth if it isn't nil.
+func newPushConfig(endpoint string, auth *PushAuth) pubsub.PushConfig {
+	config := pubsub.PushConfig{Endpoint: endpoint}
+	if auth != nil {
+		config.AuthenticationMethod = &pubsub.OIDCToken{
+			ServiceAccountEmail: auth.ServiceAccount,
+			Audience:            auth.Audience,
+		}
+	}
+	return config
+}
+
+// deadLetterTopicID returns the ID of the topic the subscriptions of t dead
+// letter to.
+func (t *Topic) deadLetterTopicID() string {
+	return fmt.Sprintf("%s-dlq", t.ID)
+}
+
+// deadLetters reports whether the subscription has dead lettering enabled,
+// which a dead letter topic enables unless dead_letter is false.
+func (s *Subscription) deadLetters() bool {
+	if s.DeadLetter != nil {
+		return *s.DeadLetter
+	}
+	return s.DeadLetterTopic != ""
+}
+
+// ownDeadLetters reports whether the subscription dead letters to the
+// "<topic>-dlq" topic and "<subscription>-dlq" subscription created with it.
+func (s *Subscription) ownDeadLetters() bool {
+	return s.deadLetters() && s.DeadLetterTopic == ""
+}
+
+// deadLetterSubscriptionID returns the ID of the subscription created on the
+// dead letter topic for s.
+func (s *Subscription) deadLetterSubscriptionID() string {
+	return fmt.Sprintf("%s-dlq", s.ID)
+}
+
+// deadLetterMaxAttempts returns how often delivery is attempted before a
+// message is dead lettered.
+func (s *Subscription) deadLetterMaxAttempts() int {
+	if s.DeadLetterMaxAttempts != nil {
+		return *s.DeadLetterMaxAttempts
+	}
+	return 5 // The default value set by GCP
+}
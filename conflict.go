@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// renamedTopics maps the full names of the topics --on-conflict suffix created
+// under another ID to the names they were created with, keyed by emulator host
+// as well, so that subscriptions in other projects dead letter to the topics
+// this run created.
+var (
+	renamedMu     sync.Mutex
+	renamedTopics = make(map[string]string)
+)
+
+// renamedTopic returns the name the topic was created with on the emulator.
+func renamedTopic(emulatorHost, name string) string {
+	renamedMu.Lock()
+	defer renamedMu.Unlock()
+	if renamed, ok := renamedTopics[emulatorHost+" "+name]; ok {
+		return renamed
+	}
+	return name
+}
+
+// suffixedID returns the first of "<id>-<run ID>", "<id>-<run ID>-2" and so on
+// that doesn't exist.
+func suffixedID(ctx context.Context, id string, exists func(ctx context.Context, id string) (bool, error)) (string, error) {
+	for n := 1; n <= 100; n++ {
+		candidate := fmt.Sprintf("%s-%s", id, runID)
+		if n > 1 {
+			candidate = fmt.Sprintf("%s-%d", candidate, n)
+		}
+		if problem := invalidName(candidate); problem != "" {
+			return "", ErrAlreadyExists.wrapf("%q already exists, and %q %s", id, candidate, problem)
+		}
+		ok, err := exists(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return candidate, nil
+		}
+	}
+	return "", ErrAlreadyExists.wrapf("%q already exists, as do 100 suffixed IDs", id)
+}
+
+// createTopic creates the topic with the labels and the schema settings, if not
+// nil, applying --on-conflict if it already exists. It returns the topic, which
+// has another ID if it was suffixed.
+func createTopic(ctx context.Context, client *pubsub.Client, project *Project, topicID string, labels map[string]string, schema *pubsub.SchemaSettings) (*pubsub.Topic, error) {
+	if *onConflict != "error" {
+		topic := client.Topic(topicID)
+		exists, err := topic.Exists(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			switch *onConflict {
+			case "skip":
+				fmt.Fprintf(logOutput, "Topic %q already exists, leaving it as it is\n", topic.String())
+				trackSkipped("create", topic.String())
+				return topic, nil
+			case "update":
+				debugf("  Updating existing topic %q", topicID)
+				// Empty settings remove the existing schema, so that the topic
+				// matches the config.
+				update := pubsub.TopicConfigToUpdate{Labels: runLabels(labels), SchemaSettings: schema}
+				if update.SchemaSettings == nil {
+					update.SchemaSettings = &pubsub.SchemaSettings{}
+				}
+				done := track("update", topic.String())
+				_, err := topic.Update(ctx, update)
+				done(err)
+				return topic, err
+			case "suffix":
+				original := topic.String()
+				topicID, err = suffixedID(ctx, topicID, func(ctx context.Context, id string) (bool, error) {
+					return client.Topic(id).Exists(ctx)
+				})
+				if err != nil {
+					return nil, err
+				}
+				renamedMu.Lock()
+				renamedTopics[project.EmulatorHost+" "+original] = topicName(project.ID, topicID)
+				renamedMu.Unlock()
+				fmt.Fprintf(logOutput, "Topic %q already exists, creating %q instead\n", original, topicName(project.ID, topicID))
+			}
+		}
+	}
+
+	done := track("create", topicName(project.ID, topicID))
+	topic, err := client.CreateTopicWithConfig(ctx, topicID, &pubsub.TopicConfig{Labels: runLabels(labels), SchemaSettings: schema})
+	done(err)
+	return topic, err
+}
+
+// createTopicSchema creates the schema of a topic, applying --on-conflict if it
+// already exists, with update committing the definition as a new revision. It
+// returns the full name of the schema, which has another ID if it was
+// suffixed.
+func createTopicSchema(ctx context.Context, client *pubsub.SchemaClient, projectID string, schema *TopicSchema) (string, error) {
+	schemaID := schema.ID
+	config := pubsub.SchemaConfig{Type: schemaTypes[schema.Type], Definition: schema.Definition}
+	exists := func(ctx context.Context, id string) (bool, error) {
+		_, err := client.Schema(ctx, id, pubsub.SchemaViewBasic)
+		if status.Code(err) == codes.NotFound {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	if *onConflict != "error" {
+		ok, err := exists(ctx, schemaID)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			name := schemaName(projectID, schemaID)
+			switch *onConflict {
+			case "skip":
+				fmt.Fprintf(logOutput, "Schema %q already exists, leaving it as it is\n", name)
+				trackSkipped("create", name)
+				return name, nil
+			case "update":
+				debugf("  Committing a revision of existing schema %q", schemaID)
+				done := track("update", name)
+				_, err := client.CommitSchema(ctx, schemaID, config)
+				done(err)
+				return name, err
+			case "suffix":
+				if schemaID, err = suffixedID(ctx, schemaID, exists); err != nil {
+					return "", err
+				}
+				fmt.Fprintf(logOutput, "Schema %q already exists, creating %q instead\n", name, schemaName(projectID, schemaID))
+			}
+		}
+	}
+
+	done := track("create", schemaName(projectID, schemaID))
+	_, err := client.CreateSchema(ctx, schemaID, config)
+	done(err)
+	return schemaName(projectID, schemaID), err
+}
+
+// createSubscription creates the subscription, applying --on-conflict if it
+// already exists. A subscription can't be updated to another topic or message
+// ordering setting, so those conflicts fail even with update.
+func createSubscription(ctx context.Context, client *pubsub.Client, projectID, subscriptionID string, config pubsub.SubscriptionConfig) error {
+	if *onConflict != "error" {
+		subscription := client.Subscription(subscriptionID)
+		exists, err := subscription.Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			switch *onConflict {
+			case "skip":
+				fmt.Fprintf(logOutput, "Subscription %q already exists, leaving it as it is\n", subscription.String())
+				trackSkipped("create", subscription.String())
+				return nil
+			case "update":
+				existing, err := subscription.Config(ctx)
+				if err != nil {
+					return err
+				}
+				if existing.Topic.String() != config.Topic.String() {
+					return ErrAlreadyExists.wrapf("Subscription %q already exists on topic %q, which can't be updated; use --on-conflict suffix", subscription.String(), existing.Topic.String())
+				}
+				if existing.EnableMessageOrdering != config.EnableMessageOrdering {
+					return ErrAlreadyExists.wrapf("Subscription %q already exists with message ordering %t, which can't be updated; use --on-conflict suffix", subscription.String(), existing.EnableMessageOrdering)
+				}
+
+				debugf("    Updating existing subscription %q", subscriptionID)
+				update := pubsub.SubscriptionConfigToUpdate{
+					PushConfig:        &config.PushConfig,
+					AckDeadline:       config.AckDeadline,
+					RetentionDuration: config.RetentionDuration,
+					Labels:            config.Labels,
+					DeadLetterPolicy:  config.DeadLetterPolicy,
+					RetryPolicy:       config.RetryPolicy,
+				}
+				// An empty policy removes the existing one, so that the
+				// subscription matches the config.
+				if update.DeadLetterPolicy == nil {
+					update.DeadLetterPolicy = &pubsub.DeadLetterPolicy{}
+				}
+				if update.RetryPolicy == nil {
+					update.RetryPolicy = &pubsub.RetryPolicy{}
+				}
+				done := track("update", subscription.String())
+				_, err = subscription.Update(ctx, update)
+				done(err)
+				return err
+			case "suffix":
+				original := subscription.String()
+				subscriptionID, err = suffixedID(ctx, subscriptionID, func(ctx context.Context, id string) (bool, error) {
+					return client.Subscription(id).Exists(ctx)
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(logOutput, "Subscription %q already exists, creating %q instead\n", original, subscriptionName(projectID, subscriptionID))
+			}
+		}
+	}
+
+	done := track("create", subscriptionName(projectID, subscriptionID))
+	_, err := client.CreateSubscription(ctx, subscriptionID, config)
+	done(err)
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+)
+
+// splitTopicName splits a full topic name into its project and topic IDs.
+func splitTopicName(name string) (projectID, topicID string, ok bool) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "topics" {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+// copyCommand replicates the topics and subscriptions of one project into
+// another, optionally prefixing their IDs.
+func copyCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("copy", flag.ExitOnError)
+	from := flags.String("from", "", "Project to copy topics and subscriptions from")
+	to := flags.String("to", "", "Project to copy topics and subscriptions to")
+	prefix := flags.String("prefix", "", "Prefix for the IDs of the copied topics and subscriptions")
+	flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		return fmt.Errorf("copy: --from and --to are required")
+	}
+	if *from == *to && *prefix == "" {
+		return fmt.Errorf("copy: --prefix is required to copy a project into itself")
+	}
+
+	src, err := newClient(ctx, *from, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", *from)
+	}
+	defer src.Close()
+	dst, err := newClient(ctx, *to, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", *to)
+	}
+	defer dst.Close()
+	srcSchemas, err := newSchemaClient(ctx, *from, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create schema client to project %q", *from)
+	}
+	defer srcSchemas.Close()
+	dstSchemas, err := newSchemaClient(ctx, *to, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create schema client to project %q", *to)
+	}
+	defer dstSchemas.Close()
+
+	// copiedTopic returns the name of the copy of a topic in the source
+	// project, and leaves other topics alone.
+	copiedTopic := func(name string) string {
+		if projectID, topicID, ok := splitTopicName(name); ok && projectID == *from {
+			return topicName(*to, *prefix+topicID)
+		}
+		return name
+	}
+
+	// copiedSchema returns the name of the copy of a schema in the source
+	// project, creating it before the first topic using it, and leaves other
+	// schemas alone.
+	schemas := make(map[string]string)
+	copiedSchema := func(name string) (string, error) {
+		projectID, schemaID, ok := splitSchemaName(name)
+		if !ok || projectID != *from {
+			return name, nil
+		}
+		if copied, ok := schemas[name]; ok {
+			return copied, nil
+		}
+		schema, err := readTopicSchema(ctx, srcSchemas, *from, schemaID)
+		if err != nil {
+			return "", err
+		}
+		schema.ID = *prefix + schemaID
+		debugf("  Creating %s schema %q", schema.Type, schema.ID)
+		copied, err := createTopicSchema(ctx, dstSchemas, *to, schema)
+		if err != nil {
+			return "", apiErrorf(err, "Unable to create schema %q for project %q", schema.ID, *to)
+		}
+		schemas[name] = copied
+		return copied, nil
+	}
+
+	debugf("Copying topics from project %q to project %q", *from, *to)
+	topics := src.Topics(ctx)
+	for {
+		topic, err := topics.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return apiErrorf(err, "Unable to list topics for project %q", *from)
+		}
+
+		config, err := topic.Config(ctx)
+		if err != nil {
+			return apiErrorf(err, "Unable to get topic %q for project %q", topic.ID(), *from)
+		}
+
+		topicID := *prefix + topic.ID()
+		var schema *pubsub.SchemaSettings
+		if settings := config.SchemaSettings; settings != nil && settings.Schema != "" {
+			if settings.Schema == deletedSchema {
+				return fmt.Errorf("copy: The schema of topic %q for project %q was deleted, so it can't be copied", topic.ID(), *from)
+			}
+			name, err := copiedSchema(settings.Schema)
+			if err != nil {
+				return err
+			}
+			schema = &pubsub.SchemaSettings{Schema: name, Encoding: settings.Encoding}
+		}
+
+		debugf("  Creating topic %q", topicID)
+		done := track("create", topicName(*to, topicID))
+		_, err = dst.CreateTopicWithConfig(ctx, topicID, &pubsub.TopicConfig{
+			Labels:               runLabels(config.Labels),
+			MessageStoragePolicy: config.MessageStoragePolicy,
+			RetentionDuration:    config.RetentionDuration,
+			SchemaSettings:       schema,
+		})
+		done(err)
+		if err != nil {
+			return apiErrorf(err, "Unable to create topic %q for project %q", topicID, *to)
+		}
+	}
+
+	debugf("Copying subscriptions from project %q to project %q", *from, *to)
+	subscriptions := src.Subscriptions(ctx)
+	for {
+		subscription, err := subscriptions.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return apiErrorf(err, "Unable to list subscriptions for project %q", *from)
+		}
+
+		config, err := subscription.Config(ctx)
+		if err != nil {
+			return apiErrorf(err, "Unable to get subscription %q for project %q", subscription.ID(), *from)
+		}
+
+		topicProjectID, topicID, ok := splitTopicName(copiedTopic(config.Topic.String()))
+		if !ok {
+			debugf("  Skipping subscription %q of deleted topic", subscription.ID())
+			continue
+		}
+		config.Topic = dst.TopicInProject(topicID, topicProjectID)
+		config.Labels = runLabels(config.Labels)
+		if config.DeadLetterPolicy != nil {
+			config.DeadLetterPolicy.DeadLetterTopic = copiedTopic(config.DeadLetterPolicy.DeadLetterTopic)
+		}
+
+		subscriptionID := *prefix + subscription.ID()
+		debugf("  Creating subscription %q on topic %q", subscriptionID, topicID)
+		done := track("create", subscriptionName(*to, subscriptionID))
+		_, err = dst.CreateSubscription(ctx, subscriptionID, config)
+		done(err)
+		if err != nil {
+			return apiErrorf(err, "Unable to create subscription %q on topic %q for project %q", subscriptionID, topicID, *to)
+		}
+	}
+
+	return nil
+}
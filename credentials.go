@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// credentialOptions returns the client options selecting the credentials used
+// for GCP. Emulators don't use credentials.
+func credentialOptions(ctx context.Context) ([]option.ClientOption, error) {
+	var opts []option.ClientOption
+
+	// Credentials files may hold a service account key or, for workload
+	// identity federation, an external account configuration.
+	if *credentialsFile != "" {
+		data, err := os.ReadFile(*credentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read credentials file %q: %s", *credentialsFile, err)
+		}
+		var credentials struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &credentials); err != nil {
+			return nil, ErrConfigSyntax.wrapf("Unable to parse credentials file %q: %s", *credentialsFile, err)
+		}
+		debugf("Using %s credentials from %q", credentials.Type, *credentialsFile)
+		opts = append(opts, option.WithCredentialsJSON(data))
+	}
+
+	if *impersonateAccount != "" {
+		ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: *impersonateAccount,
+			Scopes:          []string{pubsub.ScopePubSub},
+		}, opts...)
+		if err != nil {
+			return nil, ErrPermissionDenied.wrapf("Unable to impersonate service account %q: %s", *impersonateAccount, err)
+		}
+		opts = []option.ClientOption{option.WithTokenSource(ts)}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// csvColumns are the columns a CSV config file can have, in any order. Only
+// project and topic are required.
+var csvColumns = []string{"project", "topic", "subscription", "type", "endpoint", "dlq", "options"}
+
+// parseCSVConfig parses a config file in CSV form, as exported from a
+// spreadsheet. The first row names the columns, and each further row declares
+// a topic, or a subscription if it has one, with the settings in the other
+// columns. Rows of the same project and topic are merged, in the order they
+// first appear.
+func parseCSVConfig(path string, data []byte) (*Config, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	// Spreadsheets drop trailing empty cells, so rows can be shorter than the
+	// header.
+	r.FieldsPerRecord = -1
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, ErrConfigSyntax.wrapf("%s: Expected a header row naming the columns", path)
+	}
+	if err != nil {
+		return nil, ErrConfigSyntax.wrapf("Unable to parse config file %q: %s", path, err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !contains(csvColumns, name) {
+			return nil, ErrConfigSyntax.wrapf("%s:1: Unknown column %q: expected %s", path, name, strings.Join(csvColumns, ", "))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, ErrConfigSyntax.wrapf("%s:1: Column %q is given more than once", path, name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["project"]; !ok {
+		return nil, ErrConfigSyntax.wrapf("%s:1: Expected a project column", path)
+	}
+	if _, ok := columns["topic"]; !ok {
+		return nil, ErrConfigSyntax.wrapf("%s:1: Expected a topic column", path)
+	}
+
+	config := &Config{}
+	projects := make(map[string]*Project)
+	topics := make(map[string]*Topic)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrConfigSyntax.wrapf("Unable to parse config file %q: %s", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		if len(record) > len(header) && strings.Join(record[len(header):], "") != "" {
+			return nil, ErrConfigSyntax.wrapf("%s:%d: Expected at most %d fields, one for each column", path, line, len(header))
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		projectID, topicID := field("project"), field("topic")
+		if projectID == "" || topicID == "" {
+			if strings.Join(record, "") == "" {
+				continue
+			}
+			return nil, ErrConfigSyntax.wrapf("%s:%d: Expected a project and topic", path, line)
+		}
+		project, ok := projects[projectID]
+		if !ok {
+			project = &Project{ID: projectID}
+			projects[projectID] = project
+			config.Projects = append(config.Projects, project)
+		}
+		topic, ok := topics[projectID+"/"+topicID]
+		if !ok {
+			topic = &Topic{ID: topicID}
+			topics[projectID+"/"+topicID] = topic
+			project.Topics = append(project.Topics, topic)
+		}
+
+		if field("subscription") == "" {
+			for _, name := range []string{"type", "endpoint", "dlq", "options"} {
+				if field(name) != "" {
+					return nil, ErrConfigSyntax.wrapf("%s:%d: Expected a subscription for the %s of topic %q", path, line, name, topicID)
+				}
+			}
+			continue
+		}
+		subscription, err := csvSubscription(field)
+		if err != nil {
+			return nil, ErrConfigSyntax.wrapf("%s:%d: %s", path, line, err)
+		}
+		topic.Subscriptions = append(topic.Subscriptions, subscription)
+	}
+	return config, nil
+}
+
+// csvSubscription returns the subscription declared by a row of a CSV config
+// file, whose columns field returns.
+func csvSubscription(field func(name string) string) (*Subscription, error) {
+	subscription := &Subscription{ID: field("subscription"), PushEndpoint: field("endpoint")}
+
+	switch strings.ToLower(field("type")) {
+	case "":
+	case "pull":
+		if subscription.PushEndpoint != "" {
+			return nil, fmt.Errorf("Pull subscription %q has an endpoint", subscription.ID)
+		}
+	case "push":
+		if subscription.PushEndpoint == "" {
+			return nil, fmt.Errorf("Push subscription %q has no endpoint", subscription.ID)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid type %q: expected pull or push", field("type"))
+	}
+
+	if options := field("options"); options != "" {
+		if err := parseCSVOptions(options, &subscription.SubscriptionSettings); err != nil {
+			return nil, fmt.Errorf("Invalid options for subscription %q: %s", subscription.ID, err)
+		}
+	}
+
+	switch dlq := field("dlq"); strings.ToLower(dlq) {
+	case "", "no", "n", "false", "0":
+	case "yes", "y", "true", "1":
+		subscription.DeadLetter = boolPtr(true)
+	default:
+		if !strings.HasPrefix(dlq, "projects/") {
+			return nil, fmt.Errorf("Invalid dlq %q: expected yes, no or a full topic name", dlq)
+		}
+		subscription.DeadLetterTopic = dlq
+	}
+	return subscription, nil
+}
+
+// parseCSVOptions sets the settings given in the options column of a CSV
+// config file, as "key=value" pairs separated by semicolons. The keys are those
+// of the config file, with dots for nested keys, as in
+// "retry_policy.minimum_backoff=10s" or "labels.team=payments".
+func parseCSVOptions(options string, settings *SubscriptionSettings) error {
+	values := make(map[string]interface{})
+	for _, option := range strings.Split(options, ";") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(option, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("expected key=value, not %q", option)
+		}
+
+		var scalar interface{}
+		if err := yaml.Unmarshal([]byte(strings.TrimSpace(value)), &scalar); err != nil {
+			return fmt.Errorf("invalid value for %q: %s", key, err)
+		}
+		path := strings.Split(strings.TrimSpace(key), ".")
+		if !contains(settingKeys, path[0]) {
+			return fmt.Errorf("unknown option %q: expected %s", path[0], strings.Join(settingKeys, ", "))
+		}
+		m := values
+		for _, part := range path[:len(path)-1] {
+			nested, ok := m[part].(map[string]interface{})
+			if !ok {
+				nested = make(map[string]interface{})
+				m[part] = nested
+			}
+			m = nested
+		}
+		m[path[len(path)-1]] = scalar
+	}
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	err = decoder.Decode(settings)
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		// The line numbers are of the YAML made from the options.
+		var problems []string
+		for _, problem := range typeErr.Errors {
+			problems = append(problems, yamlLinePrefix.ReplaceAllString(problem, ""))
+		}
+		return errors.New(strings.Join(problems, "; "))
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// settingKeys are the keys of the subscription settings in config files.
+var settingKeys = func() []string {
+	var keys []string
+	t := reflect.TypeOf(SubscriptionSettings{})
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0])
+	}
+	return keys
+}()
+
+// yamlLinePrefix matches the line number yaml.v3 starts its errors with.
+var yamlLinePrefix = regexp.MustCompile(`^line [0-9]+: `)
+
+// contains reports whether the list has s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
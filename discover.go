@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// emulatorCandidates are the hosts where an emulator is commonly found, in
+// the order they are probed.
+var emulatorCandidates = []string{"localhost:8085", "pubsub:8085", "host.docker.internal:8085"}
+
+// isEmulator reports whether host responds like a PubSub emulator, which
+// answers "Ok" on its HTTP root.
+func isEmulator(ctx context.Context, host string) bool {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := directClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 16))
+	return resp.StatusCode == http.StatusOK && strings.TrimSpace(string(body)) == "Ok"
+}
+
+// discoverEmulator returns the first of the candidate hosts running an
+// emulator.
+func discoverEmulator(ctx context.Context) (string, error) {
+	for _, host := range emulatorCandidates {
+		debugf("Looking for an emulator at %q", host)
+		if isEmulator(ctx, host) {
+			return host, nil
+		}
+	}
+	return "", ErrEmulatorUnreachable.wrapf("No emulator found at %s and PUBSUB_EMULATOR_HOST is not set", strings.Join(emulatorCandidates, ", "))
+}
+
+// useDiscoveredEmulator sets PUBSUB_EMULATOR_HOST to a discovered emulator if
+// it isn't already set.
+func useDiscoveredEmulator(ctx context.Context) error {
+	if os.Getenv("PUBSUB_EMULATOR_HOST") != "" {
+		return nil
+	}
+
+	host, err := discoverEmulator(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(logOutput, "Using the emulator found at %q\n", host)
+	return os.Setenv("PUBSUB_EMULATOR_HOST", host)
+}
+
+// waitForConnection waits up to --connect-timeout for conn to the emulator at
+// host to be ready, so that an unreachable emulator is reported as such rather
+// than as a deadline error from the first API call.
+func waitForConnection(ctx context.Context, conn *grpc.ClientConn, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, *connectTimeout)
+	defer cancel()
+
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		debugf("Waiting for the emulator at %q, connection is %s", host, state)
+		if !conn.WaitForStateChange(ctx, state) {
+			return ErrEmulatorUnreachable.wrapf("Emulator not reachable at %q after %s", host, *connectTimeout)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+)
+
+// deadLetter describes a message that arrived on a dead letter subscription,
+// as logged and sent to the webhook.
+type deadLetter struct {
+	Subscription string            `json:"subscription"`
+	MessageID    string            `json:"message_id"`
+	PublishTime  time.Time         `json:"publish_time"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Data         []byte            `json:"data"`
+}
+
+// dlqCommand runs one of the dlq subcommands.
+func dlqCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Expected a dlq command: watch")
+	}
+
+	switch args[0] {
+	case "watch":
+		return dlqWatch(ctx, args[1:])
+	default:
+		return fmt.Errorf("Unknown dlq command %q", args[0])
+	}
+}
+
+// watchedSubscription is a dead letter subscription to watch, by full name and
+// the emulator its project is on.
+type watchedSubscription struct {
+	EmulatorHost string
+	Name         string
+}
+
+// deadLetterSubscriptions returns the dead letter subscriptions to watch: those
+// ending in "-dlq" in the project, if one is given, on each --emulator-host,
+// or else those the config declares, on the emulators of their projects.
+func deadLetterSubscriptions(ctx context.Context, projectID string) ([]watchedSubscription, error) {
+	var watched []watchedSubscription
+	if projectID != "" {
+		hosts := []string(emulatorHosts)
+		if len(hosts) == 0 {
+			hosts = []string{""}
+		}
+		for _, host := range hosts {
+			client, err := newClient(ctx, projectID, host)
+			if err != nil {
+				return nil, apiErrorf(err, "Unable to create client to project %q", projectID)
+			}
+			defer client.Close()
+
+			subscriptions := client.Subscriptions(ctx)
+			for {
+				subscription, err := subscriptions.Next()
+				if err == iterator.Done {
+					break
+				}
+				if err != nil {
+					return nil, apiErrorf(err, "Unable to list subscriptions for project %q", projectID)
+				}
+				if strings.HasSuffix(subscription.ID(), "-dlq") {
+					watched = append(watched, watchedSubscription{EmulatorHost: host, Name: subscriptionName(projectID, subscription.ID())})
+				}
+			}
+		}
+		return watched, nil
+	}
+
+	config, err := loadConfig(*configFile, *projectsFile, nameData(*branch, *buildID, *nameUser, runID))
+	if err != nil {
+		return nil, err
+	}
+	for _, project := range config.Projects {
+		for _, topic := range project.Topics {
+			for _, subscription := range topic.Subscriptions {
+				if subscription.ownDeadLetters() {
+					watched = append(watched, watchedSubscription{
+						EmulatorHost: project.EmulatorHost,
+						Name:         subscriptionName(project.ID, subscription.deadLetterSubscriptionID()),
+					})
+				}
+			}
+		}
+	}
+	return watched, nil
+}
+
+// postDeadLetter sends a dead lettered message to the webhook.
+func postDeadLetter(ctx context.Context, webhook string, d deadLetter) error {
+	body, _ := json.Marshal(d)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// dlqWatch receives from dead letter subscriptions, reporting every message
+// that arrives. Unless told to keep going, it stops with an error at the first
+// message, so that an unexpected dead letter fails the build.
+func dlqWatch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dlq watch", flag.ExitOnError)
+	projectID := flags.String("project", "", "Watch the subscriptions ending in -dlq in this project, instead of those declared in the config")
+	webhook := flags.String("webhook", "", "POST each dead lettered message as JSON to this URL")
+	keepGoing := flags.Bool("keep-going", false, "Keep watching after a message arrives instead of exiting with an error")
+	receiveSettings := addReceiveFlags(flags)
+	flags.Parse(args)
+
+	watched, err := deadLetterSubscriptions(ctx, *projectID)
+	if err != nil {
+		return err
+	}
+	if len(watched) == 0 {
+		return fmt.Errorf("dlq watch: There are no dead letter subscriptions to watch")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var watchErr error
+	fail := func(err error) {
+		mu.Lock()
+		if watchErr == nil {
+			watchErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	clients := make(map[string]*pubsub.Client)
+	var wg sync.WaitGroup
+	for _, w := range watched {
+		name := w.Name
+		parts := strings.Split(name, "/")
+		subscriptionProjectID, subscriptionID := parts[1], parts[3]
+
+		client, ok := clients[w.EmulatorHost+" "+subscriptionProjectID]
+		if !ok {
+			client, err = newClient(ctx, subscriptionProjectID, w.EmulatorHost)
+			if err != nil {
+				return apiErrorf(err, "Unable to create client to project %q", subscriptionProjectID)
+			}
+			defer client.Close()
+			clients[w.EmulatorHost+" "+subscriptionProjectID] = client
+		}
+
+		subscription := client.Subscription(subscriptionID)
+		receiveSettings.apply(subscription)
+
+		debugf("Watching dead letter subscription %q", name)
+		wg.Add(1)
+		go func(name string, subscription *pubsub.Subscription) {
+			defer wg.Done()
+			err := subscription.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+				d := deadLetter{
+					Subscription: name,
+					MessageID:    m.ID,
+					PublishTime:  m.PublishTime,
+					Attributes:   m.Attributes,
+					Data:         m.Data,
+				}
+				line, _ := json.Marshal(d)
+				fmt.Fprintf(os.Stderr, "%s: Dead lettered message on %s: %s\n", os.Args[0], name, line)
+
+				if *webhook != "" {
+					if err := postDeadLetter(ctx, *webhook, d); err != nil {
+						fmt.Fprintf(os.Stderr, "%s: Unable to notify webhook: %s\n", os.Args[0], err)
+					}
+				}
+				m.Ack()
+
+				if !*keepGoing {
+					fail(ErrDeadLettered.wrapf("Message %q arrived on dead letter subscription %q", m.ID, name))
+				}
+			})
+			if err != nil && !errors.Is(err, context.Canceled) {
+				fail(apiErrorf(err, "Unable to receive from subscription %q", name))
+			}
+		}(name, subscription)
+	}
+	wg.Wait()
+
+	return watchErr
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// encoder turns the JSON data of an input message into the data to publish.
+type encoder func(data json.RawMessage) ([]byte, error)
+
+// protoEncoder returns an encoder to binary protobuf messages of messageType,
+// which must be defined in protoFile. Imports are resolved relative to the
+// directory of protoFile, and the well-known types are always available.
+func protoEncoder(ctx context.Context, protoFile, messageType string) (encoder, error) {
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			ImportPaths: []string{filepath.Dir(protoFile)},
+		}),
+	}
+	files, err := compiler.Compile(ctx, filepath.Base(protoFile))
+	if err != nil {
+		return nil, ErrConfigSyntax.wrapf("Unable to compile %q: %s", protoFile, err)
+	}
+
+	descriptor := files[0].FindDescriptorByName(protoreflect.FullName(messageType))
+	messageDescriptor, ok := descriptor.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("Message type %q is not defined in %q", messageType, protoFile)
+	}
+
+	return func(data json.RawMessage) ([]byte, error) {
+		message := dynamicpb.NewMessage(messageDescriptor)
+		if err := protojson.Unmarshal(data, message); err != nil {
+			return nil, fmt.Errorf("Unable to encode %s: %s", messageType, err)
+		}
+		return proto.Marshal(message)
+	}, nil
+}
+
+// avroEncoder returns an encoder to Avro records of the schema in schemaFile,
+// in the binary encoding or, if textual is set, the JSON encoding. The JSON
+// data of input messages is in the JSON encoding either way, so unions are
+// wrapped in an object naming their type.
+func avroEncoder(schemaFile string, textual bool) (encoder, error) {
+	schema, err := os.ReadFile(schemaFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read Avro schema %q: %s", schemaFile, err)
+	}
+	codec, err := goavro.NewCodec(string(schema))
+	if err != nil {
+		return nil, ErrConfigSyntax.wrapf("Unable to parse Avro schema %q: %s", schemaFile, err)
+	}
+
+	return func(data json.RawMessage) ([]byte, error) {
+		native, _, err := codec.NativeFromTextual(data)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to encode Avro record: %s", err)
+		}
+		if textual {
+			return codec.TextualFromNative(nil, native)
+		}
+		return codec.BinaryFromNative(nil, native)
+	}, nil
+}
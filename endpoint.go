@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// endpointTimeout bounds each probe of a push endpoint.
+const endpointTimeout = 5 * time.Second
+
+// directClient is used for local services such as push endpoints and
+// emulators, which are never reached through a proxy.
+var directClient = &http.Client{Transport: &http.Transport{Proxy: nil}}
+
+// probeEndpoint checks a push endpoint is reachable by sending it an HTTP HEAD
+// request. Any response counts as reachable, as push handlers rarely support
+// HEAD.
+func probeEndpoint(ctx context.Context, endpoint string) error {
+	ctx, cancel := context.WithTimeout(ctx, endpointTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := directClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// endpointAddress returns the host and port a push endpoint URL connects to.
+func endpointAddress(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", ErrBadPushEndpoint.wrapf("Invalid push endpoint %q: %s", endpoint, err)
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(u.Hostname(), "80"), nil
+}
+
+// waitForEndpoint blocks until the push endpoint accepts TCP connections or
+// the timeout passes.
+func waitForEndpoint(ctx context.Context, endpoint string, timeout time.Duration) error {
+	address, err := endpointAddress(endpoint)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	for {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err == nil {
+			return conn.Close()
+		}
+
+		debugf("      Waiting for push endpoint %q: %s", endpoint, err)
+		select {
+		case <-ctx.Done():
+			return ErrBadPushEndpoint.wrapf("Push endpoint %q did not accept connections within %s", endpoint, timeout)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// checkEndpoints probes the push endpoints of every subscription in project.
+// Depending on mode, unreachable endpoints are ignored ("skip"), reported on
+// stderr ("warn") or returned as an error ("fail").
+func checkEndpoints(ctx context.Context, project *Project, mode string) error {
+	if mode == "skip" {
+		return nil
+	}
+
+	var failures []string
+	for _, topic := range project.Topics {
+		for _, subscription := range topic.Subscriptions {
+			endpoint := subscription.PushEndpoint
+			if endpoint == "" {
+				continue
+			}
+
+			debugf("  Checking push endpoint %q of topic %q", endpoint, topic.ID)
+			if err := probeEndpoint(ctx, endpoint); err != nil {
+				failures = append(failures, fmt.Sprintf("push endpoint %q on topic %q for project %q is unreachable: %s", endpoint, topic.ID, project.ID, err))
+			}
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+	if mode == "fail" {
+		return ErrBadPushEndpoint.wrapf("Unable to reach push endpoints:\n  %s", strings.Join(failures, "\n  "))
+	}
+	for _, failure := range failures {
+		fmt.Fprintf(os.Stderr, "%s: Warning: %s\n", os.Args[0], failure)
+	}
+	return nil
+}
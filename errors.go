@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error is an error with a stable code and a hint on how to fix it. The
+// exported Err values are the kinds of error; use errors.Is to test for them.
+type Error struct {
+	Code string
+	Hint string
+	Err  error
+}
+
+// The kinds of error pubsubc reports.
+var (
+	ErrConfigSyntax = &Error{
+		Code: "config-syntax",
+		Hint: "Check the PUBSUB_PROJECT variables and config file against the formats in the README.",
+	}
+	ErrConfigInvalid = &Error{
+		Code: "config-invalid",
+		Hint: "Fix the resources listed above; every ID must be unique within its project and follow the Pub/Sub naming rules.",
+	}
+	ErrEmulatorUnreachable = &Error{
+		Code: "emulator-unreachable",
+		Hint: "Check the emulator is running and that PUBSUB_EMULATOR_HOST or PUBSUB_PROJECTn_EMULATOR_HOST points at it.",
+	}
+	ErrBadPushEndpoint = &Error{
+		Code: "bad-push-endpoint",
+		Hint: "Check the push endpoint's host and port for typos and that its service is listening; use --check-endpoints to find these early.",
+	}
+	ErrAlreadyExists = &Error{
+		Code: "already-exists",
+		Hint: "The resource was created by an earlier run; restart the emulator to start from a clean state, or choose what to do with --on-conflict.",
+	}
+	ErrPermissionDenied = &Error{
+		Code: "permission-denied",
+		Hint: "Check the credentials in use are allowed to manage Pub/Sub in the project.",
+	}
+	ErrTooManyResources = &Error{
+		Code: "too-many-resources",
+		Hint: "Check the config and name templates for loops or repeated definitions, or raise --max-topics and --max-subscriptions.",
+	}
+	ErrMissing = &Error{
+		Code: "missing",
+		Hint: "The resources were deleted or never created; run pubsubc with the same config to create them.",
+	}
+	ErrNotDelivered = &Error{
+		Code: "not-delivered",
+		Hint: "Check the subscription's filter and that nothing else is consuming from it, or allow longer for delivery.",
+	}
+	ErrDeadLettered = &Error{
+		Code: "dead-lettered",
+		Hint: "A subscriber failed to handle the message; check its logs around the message's publish time.",
+	}
+)
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Code
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the same kind of error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// wrap returns err as an error of this kind.
+func (e *Error) wrap(err error) error {
+	return &Error{Code: e.Code, Hint: e.Hint, Err: err}
+}
+
+// wrapf returns a formatted error of this kind.
+func (e *Error) wrapf(format string, params ...interface{}) error {
+	return e.wrap(fmt.Errorf(format, params...))
+}
+
+// apiErrorf describes an error returned by the PubSub API, giving it the kind
+// that matches its status code, or keeping its kind if it already has one.
+func apiErrorf(err error, format string, params ...interface{}) error {
+	described := fmt.Errorf(format+": %s", append(params, err)...)
+	var e *Error
+	if errors.As(err, &e) {
+		return e.wrap(described)
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return ErrEmulatorUnreachable.wrap(described)
+	case codes.AlreadyExists:
+		return ErrAlreadyExists.wrap(described)
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return ErrPermissionDenied.wrap(described)
+	}
+	return described
+}
+
+// errorCode returns the code of err, or an empty string if it has none.
+func errorCode(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// fatal prints an error to stderr, along with its code and hint if it has
+// them, and exits.
+func fatal(err error) {
+	var e *Error
+	if errors.As(err, &e) {
+		fmt.Fprintf(os.Stderr, "%s: [%s] %s\n  Hint: %s\n", os.Args[0], e.Code, err, e.Hint)
+		os.Exit(1)
+	}
+	fatalf("%s", err)
+}
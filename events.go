@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// event is a single line of the NDJSON event stream.
+type event struct {
+	Time       time.Time      `json:"time"`
+	Type       string         `json:"type"`
+	Operation  string         `json:"operation,omitempty"`
+	Target     string         `json:"target,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Code       string         `json:"code,omitempty"`
+	Counts     map[string]int `json:"counts,omitempty"`
+	Success    *bool          `json:"success,omitempty"`
+	DurationMS int64          `json:"duration_ms,omitempty"`
+	RunID      string         `json:"run_id"`
+}
+
+var (
+	eventsMu     sync.Mutex
+	eventsOutput io.Writer
+	eventsStart  = time.Now()
+	outcomes     = map[string]int{"created": 0, "updated": 0, "skipped": 0, "deleted": 0, "failed": 0}
+)
+
+// operationOutcomes maps each tracked operation to the outcome it is counted
+// under in the summary when it succeeds.
+var operationOutcomes = map[string]string{"create": "created", "update": "updated", "delete": "deleted"}
+
+// openEvents starts writing events to path, or to stdout if path is empty.
+// Until it is called, events are discarded.
+func openEvents(path string) error {
+	if path == "" {
+		eventsOutput = os.Stdout
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Unable to open events file %q: %s", path, err)
+	}
+	eventsOutput = f
+	return nil
+}
+
+// emit writes an event to the event stream.
+func emit(e event) {
+	if eventsOutput == nil {
+		return
+	}
+
+	e.Time = time.Now().UTC()
+	e.RunID = runID
+	line, _ := json.Marshal(e)
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	eventsOutput.Write(append(line, '\n'))
+}
+
+// emitParsed reports the size of the parsed config.
+func emitParsed(config *Config) {
+	counts := map[string]int{"projects": len(config.Projects)}
+	for _, project := range config.Projects {
+		counts["topics"] += len(project.Topics)
+		for _, topic := range project.Topics {
+			counts["subscriptions"] += len(topic.Subscriptions)
+		}
+	}
+	emit(event{Type: "parse", Counts: counts})
+}
+
+// emitSummary reports the outcome of the whole run, to the event stream and
+// --notify-url.
+func emitSummary(err error) {
+	counts := make(map[string]int)
+	eventsMu.Lock()
+	for outcome, n := range outcomes {
+		counts[outcome] = n
+	}
+	eventsMu.Unlock()
+	e := event{
+		Type:       "summary",
+		Counts:     counts,
+		Success:    boolPtr(err == nil),
+		DurationMS: time.Since(eventsStart).Milliseconds(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+		e.Code = errorCode(err)
+	}
+	emit(e)
+	notify(e)
+}
+
+// track reports the start of an operation on the target resource and returns
+// a function that reports its outcome to the event stream and audit log.
+func track(operation, target string) func(error) {
+	emit(event{Type: operation + "-start", Operation: operation, Target: target})
+	start := time.Now()
+
+	return func(err error) {
+		audit(operation, target, err)
+
+		e := event{Operation: operation, Target: target, DurationMS: time.Since(start).Milliseconds()}
+		eventsMu.Lock()
+		if err != nil {
+			outcomes["failed"]++
+			e.Type = "error"
+			e.Error = err.Error()
+			e.Code = errorCode(err)
+		} else {
+			outcomes[operationOutcomes[operation]]++
+			e.Type = operation + "-done"
+		}
+		eventsMu.Unlock()
+		emit(e)
+	}
+}
+
+// trackSkipped reports that an operation on the target resource was skipped
+// because the resource already exists.
+func trackSkipped(operation, target string) {
+	eventsMu.Lock()
+	outcomes["skipped"]++
+	eventsMu.Unlock()
+	emit(event{Type: operation + "-skipped", Operation: operation, Target: target})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+)
+
+// explainCommand prints the config pubsubc would create, after the
+// PUBSUB_PROJECT variables are merged in, names are expanded and defaults are
+// applied. The defaults themselves are left out, as every subscription already
+// has them, so the output loads as the same config without the variables.
+func explainCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	flags.Parse(args)
+
+	config, err := loadConfig(*configFile, *projectsFile, nameData(*branch, *buildID, *nameUser, runID))
+	if err != nil {
+		return err
+	}
+
+	config.Defaults = SubscriptionSettings{}
+	for _, project := range config.Projects {
+		for _, topic := range project.Topics {
+			topic.Defaults = SubscriptionSettings{}
+		}
+	}
+	return writeYAML(os.Stdout, config)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// publishFlags are the batching and flow control flags of the commands that
+// publish.
+type publishFlags struct {
+	delayThreshold         *time.Duration
+	countThreshold         *int
+	maxOutstandingMessages *int
+	maxOutstandingBytes    *int
+}
+
+// addPublishFlags defines the publish flags on flags, defaulting to the client
+// library's settings.
+func addPublishFlags(flags *flag.FlagSet) *publishFlags {
+	defaults := pubsub.DefaultPublishSettings
+	return &publishFlags{
+		delayThreshold:         flags.Duration("delay-threshold", defaults.DelayThreshold, "Send a batch of messages after waiting this long for it to fill"),
+		countThreshold:         flags.Int("count-threshold", defaults.CountThreshold, "Send a batch of messages once it has this many"),
+		maxOutstandingMessages: flags.Int("max-outstanding-messages", 0, "Block publishing while this many messages are unsent or unconfirmed, 0 for no limit"),
+		maxOutstandingBytes:    flags.Int("max-outstanding-bytes", 0, "Block publishing while this many bytes are unsent or unconfirmed, 0 for no limit"),
+	}
+}
+
+// apply sets the publish settings of topic from the flags.
+func (f *publishFlags) apply(topic *pubsub.Topic) {
+	topic.PublishSettings.DelayThreshold = *f.delayThreshold
+	topic.PublishSettings.CountThreshold = *f.countThreshold
+	if *f.maxOutstandingMessages > 0 || *f.maxOutstandingBytes > 0 {
+		topic.PublishSettings.FlowControlSettings = pubsub.FlowControlSettings{
+			MaxOutstandingMessages: *f.maxOutstandingMessages,
+			MaxOutstandingBytes:    *f.maxOutstandingBytes,
+			LimitExceededBehavior:  pubsub.FlowControlBlock,
+		}
+	}
+}
+
+// receiveFlags are the flow control flags of the commands that receive.
+type receiveFlags struct {
+	maxOutstandingMessages *int
+	maxOutstandingBytes    *int
+	numGoroutines          *int
+}
+
+// addReceiveFlags defines the receive flags on flags, defaulting to the client
+// library's settings. They are prefixed so that they don't clash with the
+// publish flags of commands that do both.
+func addReceiveFlags(flags *flag.FlagSet) *receiveFlags {
+	defaults := pubsub.DefaultReceiveSettings
+	return &receiveFlags{
+		maxOutstandingMessages: flags.Int("receive-max-outstanding-messages", defaults.MaxOutstandingMessages, "Stop pulling while this many messages are unacknowledged, negative for no limit"),
+		maxOutstandingBytes:    flags.Int("receive-max-outstanding-bytes", defaults.MaxOutstandingBytes, "Stop pulling while this many bytes are unacknowledged, negative for no limit"),
+		numGoroutines:          flags.Int("receive-goroutines", defaults.NumGoroutines, "Number of streams pulling messages"),
+	}
+}
+
+// apply sets the receive settings of subscription from the flags.
+func (f *receiveFlags) apply(subscription *pubsub.Subscription) {
+	subscription.ReceiveSettings.MaxOutstandingMessages = *f.maxOutstandingMessages
+	subscription.ReceiveSettings.MaxOutstandingBytes = *f.maxOutstandingBytes
+	subscription.ReceiveSettings.NumGoroutines = *f.numGoroutines
+}
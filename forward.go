@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// pushRequest is the body Pub/Sub sends push endpoints.
+type pushRequest struct {
+	Message      pushMessage `json:"message"`
+	Subscription string      `json:"subscription"`
+}
+
+type pushMessage struct {
+	Data        []byte            `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	MessageID   string            `json:"messageId"`
+	PublishTime time.Time         `json:"publishTime"`
+	OrderingKey string            `json:"orderingKey,omitempty"`
+}
+
+// forwardCommand receives from a pull subscription and forwards every message
+// to an HTTP endpoint the way a push subscription would, acknowledging it if
+// the endpoint succeeds. This lets a local handler serve a subscription it
+// can't be pushed to, and lets delays and failures be injected to test how the
+// handler copes with them.
+func forwardCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("forward", flag.ExitOnError)
+	projectID := flags.String("project", defaultProjectID(), "Project ID containing the subscription")
+	subscriptionID := flags.String("subscription", "", "Pull subscription to forward messages from")
+	endpoint := flags.String("endpoint", "", "URL to POST the messages to")
+	timeout := flags.Duration("timeout", 60*time.Second, "How long the endpoint has to respond, as with a push subscription's ack deadline")
+	var injected faults
+	flags.DurationVar(&injected.Delay, "inject-delay", 0, "Wait this long before forwarding each message")
+	flags.Float64Var(&injected.ErrorRate, "inject-error-rate", 0, "Fraction of deliveries, from 0 to 1, to fail without forwarding, so the message is redelivered")
+	receiveSettings := addReceiveFlags(flags)
+	flags.Parse(args)
+
+	if *projectID == "" || *subscriptionID == "" || *endpoint == "" {
+		return fmt.Errorf("forward: --project, --subscription and --endpoint are required")
+	}
+	if err := injected.check("--inject-delay", "--inject-error-rate"); err != nil {
+		return fmt.Errorf("forward: %s", err)
+	}
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", *projectID)
+	}
+	defer client.Close()
+
+	subscription := client.Subscription(*subscriptionID)
+	receiveSettings.apply(subscription)
+	name := subscriptionName(*projectID, *subscriptionID)
+
+	debugf("Forwarding subscription %q to %q", *subscriptionID, *endpoint)
+	err = subscription.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		if err := injected.inject(ctx); err != nil {
+			debugf("  Failed message %q: %s", m.ID, err)
+			m.Nack()
+			return
+		}
+
+		if err := forwardMessage(ctx, *endpoint, *timeout, name, m); err != nil {
+			debugf("  Unable to forward message %q: %s", m.ID, err)
+			m.Nack()
+			return
+		}
+		debugf("  Forwarded message %q", m.ID)
+		m.Ack()
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return apiErrorf(err, "Unable to receive from subscription %q for project %q", *subscriptionID, *projectID)
+	}
+	return nil
+}
+
+// faults are the delays and failures injected into deliveries.
+type faults struct {
+	Delay     time.Duration
+	ErrorRate float64
+}
+
+// check checks the faults can be injected, naming the delay and error rate as
+// given in errors.
+func (f faults) check(delay, errorRate string) error {
+	if f.Delay < 0 {
+		return fmt.Errorf("%s must not be negative, not %s", delay, f.Delay)
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 {
+		return fmt.Errorf("%s must be between 0 and 1, not %g", errorRate, f.ErrorRate)
+	}
+	return nil
+}
+
+// inject fails a delivery at the error rate, or else waits for the delay,
+// returning an error if the delivery fails or ctx is done first. A failed
+// delivery isn't delayed, so that it is redelivered sooner.
+func (f faults) inject(ctx context.Context) error {
+	if f.ErrorRate > 0 && rand.Float64() < f.ErrorRate {
+		return errors.New("injected failure")
+	}
+	if f.Delay > 0 {
+		select {
+		case <-time.After(f.Delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// endpointFaults holds the faults of a repeated flag of "path=delay,rate"
+// values, by path.
+type endpointFaults map[string]faults
+
+func (e endpointFaults) String() string {
+	var values []string
+	for path, f := range e {
+		values = append(values, fmt.Sprintf("%s=%s,%g", path, f.Delay, f.ErrorRate))
+	}
+	sort.Strings(values)
+	return strings.Join(values, " ")
+}
+
+func (e endpointFaults) Set(s string) error {
+	path, spec, ok := strings.Cut(s, "=")
+	if !ok || !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("expected path=delay,rate with a path starting with /, not %q", s)
+	}
+	if _, ok := e[path]; ok {
+		return fmt.Errorf("path %q is given more than once", path)
+	}
+
+	var f faults
+	delay, rate, _ := strings.Cut(spec, ",")
+	var err error
+	if f.Delay, err = time.ParseDuration(delay); err != nil {
+		return fmt.Errorf("invalid delay for path %q: %s", path, err)
+	}
+	if rate != "" {
+		if f.ErrorRate, err = strconv.ParseFloat(rate, 64); err != nil {
+			return fmt.Errorf("invalid error rate for path %q: %s", path, err)
+		}
+	}
+	if err := f.check(fmt.Sprintf("the delay for path %q", path), fmt.Sprintf("the error rate for path %q", path)); err != nil {
+		return err
+	}
+	e[path] = f
+	return nil
+}
+
+// pushDelivery describes a push request the mock push server received, as it
+// logs them.
+type pushDelivery struct {
+	Path         string            `json:"path"`
+	Subscription string            `json:"subscription,omitempty"`
+	MessageID    string            `json:"message_id"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Data         []byte            `json:"data"`
+	Status       int               `json:"status"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// mockPushCommand serves push endpoints that accept every message Pub/Sub
+// pushes to them and print it as a JSON line, so that push subscriptions can
+// be tested without their real handlers. Delays and failures can be injected
+// for all paths, or for each path, to test how subscriptions retry and dead
+// letter.
+func mockPushCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("mock-push", flag.ExitOnError)
+	port := flags.Int("port", 8080, "Port to serve the push endpoints on")
+	var injected faults
+	flags.DurationVar(&injected.Delay, "inject-delay", 0, "Wait this long before responding to each push")
+	flags.Float64Var(&injected.ErrorRate, "inject-error-rate", 0, "Fraction of pushes, from 0 to 1, to fail with a 503 status, so the message is redelivered")
+	perPath := make(endpointFaults)
+	flags.Var(perPath, "endpoint-fault", "Delay and error rate for one path instead of --inject-delay and --inject-error-rate, as path=delay,rate; repeat for each path")
+	flags.Parse(args)
+
+	if err := injected.check("--inject-delay", "--inject-error-rate"); err != nil {
+		return fmt.Errorf("mock-push: %s", err)
+	}
+
+	var mu sync.Mutex
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// --check-endpoints probes with HEAD requests.
+		if r.Method == http.MethodHead {
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		d := pushDelivery{Path: r.URL.Path, Status: http.StatusNoContent}
+		var req pushRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			d.Status, d.Error = http.StatusBadRequest, fmt.Sprintf("invalid push request: %s", err)
+		} else {
+			d.Subscription, d.MessageID, d.Attributes, d.Data = req.Subscription, req.Message.MessageID, req.Message.Attributes, req.Message.Data
+			f, ok := perPath[r.URL.Path]
+			if !ok {
+				f = injected
+			}
+			if err := f.inject(r.Context()); err != nil {
+				d.Status, d.Error = http.StatusServiceUnavailable, err.Error()
+			}
+		}
+
+		line, _ := json.Marshal(d)
+		mu.Lock()
+		fmt.Printf("%s\n", line)
+		mu.Unlock()
+		if d.Error != "" {
+			http.Error(w, d.Error, d.Status)
+			return
+		}
+		w.WriteHeader(d.Status)
+	})
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		return fmt.Errorf("Unable to listen on port %d: %s", *port, err)
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Fprintf(logOutput, "Serving push endpoints on http://localhost:%d\n", *port)
+	<-ctx.Done()
+	return nil
+}
+
+// forwardMessage POSTs a message to the endpoint in the push format. As with
+// push subscriptions, only the success statuses Pub/Sub accepts count as
+// handled.
+func forwardMessage(ctx context.Context, endpoint string, timeout time.Duration, subscription string, m *pubsub.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, _ := json.Marshal(pushRequest{
+		Message: pushMessage{
+			Data:        m.Data,
+			Attributes:  m.Attributes,
+			MessageID:   m.ID,
+			PublishTime: m.PublishTime,
+			OrderingKey: m.OrderingKey,
+		},
+		Subscription: subscription,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := directClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent, http.StatusProcessing:
+		return nil
+	}
+	return fmt.Errorf("endpoint responded %s", resp.Status)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// emulatorImage is the image the generated configurations run the emulator
+// from.
+const emulatorImage = "gcr.io/google.com/cloudsdktool/google-cloud-cli:emulators"
+
+// generateCommand runs one of the generate subcommands.
+func generateCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Expected something to generate: compose or k8s")
+	}
+
+	switch args[0] {
+	case "compose":
+		return generateCompose(args[1:])
+	case "k8s":
+		return generateK8s(args[1:])
+	default:
+		return fmt.Errorf("Unknown generator %q", args[0])
+	}
+}
+
+// provisioning describes how the current invocation provisions projects, so
+// that generated configurations can run pubsubc the same way.
+type provisioning struct {
+	// Environment holds the PUBSUB_PROJECT variables, including those for the
+	// --projects-file definitions, and PUBSUBC_DELIMITERS.
+	Environment map[string]string
+	// Args are the pubsubc arguments, using ConfigPath for the config file.
+	Args []string
+	// ConfigFile is the config file given with --config, if any, and
+	// ConfigPath is where it is made available to the generated pubsubc.
+	ConfigFile string
+	ConfigPath string
+}
+
+// currentProvisioning loads the current config, checking it is valid, and
+// describes how to provision it. Per-project emulator hosts are left out, as
+// the generated configurations run a single emulator.
+func currentProvisioning(configDir string) (*provisioning, error) {
+	config, err := loadConfig(*configFile, *projectsFile, nameData(*branch, *buildID, *nameUser, runID))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &provisioning{Environment: make(map[string]string)}
+	i := 1
+	for ; ; i++ {
+		currentEnv := fmt.Sprintf("PUBSUB_PROJECT%d", i)
+		env := os.Getenv(currentEnv)
+		if env == "" {
+			break
+		}
+		p.Environment[currentEnv] = env
+	}
+
+	// The --projects-file definitions carry on the numbering.
+	if *projectsFile != "" {
+		definitions, _, err := projectDefinitions(*projectsFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, definition := range definitions {
+			p.Environment[fmt.Sprintf("PUBSUB_PROJECT%d", i)] = definition
+			i++
+		}
+	}
+	if *delimiterList != "" {
+		p.Environment["PUBSUBC_DELIMITERS"] = *delimiterList
+	}
+
+	if *configFile != "" {
+		p.ConfigFile = *configFile
+		p.ConfigPath = configDir + "/" + filepath.Base(*configFile)
+		p.Args = append(p.Args, "--config", p.ConfigPath)
+	}
+
+	// The subscribers usually start alongside pubsubc, so wait for them.
+	push := false
+	for _, project := range config.Projects {
+		for _, topic := range project.Topics {
+			for _, subscription := range topic.Subscriptions {
+				push = push || subscription.PushEndpoint != ""
+			}
+		}
+	}
+	if push {
+		p.Args = append(p.Args, "--wait-for-endpoints", "60s")
+	}
+
+	return p, nil
+}
+
+// composeService is a service of a Docker Compose file.
+type composeService struct {
+	Image       string                       `yaml:"image"`
+	Command     []string                     `yaml:"command,omitempty"`
+	Ports       []string                     `yaml:"ports,omitempty"`
+	Environment map[string]string            `yaml:"environment,omitempty"`
+	Volumes     []string                     `yaml:"volumes,omitempty"`
+	Healthcheck *composeHealthcheck          `yaml:"healthcheck,omitempty"`
+	DependsOn   map[string]composeDependency `yaml:"depends_on,omitempty"`
+}
+
+type composeHealthcheck struct {
+	Test     []string `yaml:"test"`
+	Interval string   `yaml:"interval"`
+	Retries  int      `yaml:"retries"`
+}
+
+type composeDependency struct {
+	Condition string `yaml:"condition"`
+}
+
+// generateCompose prints Docker Compose services running an emulator and
+// pubsubc provisioning the current config on it.
+func generateCompose(args []string) error {
+	flags := flag.NewFlagSet("generate compose", flag.ExitOnError)
+	image := flags.String("image", "pubsubc", "Image to run pubsubc from")
+	port := flags.Int("port", 8085, "Port to expose the emulator on")
+	flags.Parse(args)
+
+	p, err := currentProvisioning("/etc/pubsubc")
+	if err != nil {
+		return err
+	}
+
+	emulatorAddress := fmt.Sprintf("0.0.0.0:%d", *port)
+	pubsubc := composeService{
+		Image:       *image,
+		Command:     p.Args,
+		Environment: p.Environment,
+		DependsOn:   map[string]composeDependency{"pubsub": {Condition: "service_healthy"}},
+	}
+	pubsubc.Environment["PUBSUB_EMULATOR_HOST"] = fmt.Sprintf("pubsub:%d", *port)
+	if p.ConfigFile != "" {
+		source := filepath.ToSlash(p.ConfigFile)
+		if !filepath.IsAbs(p.ConfigFile) && !strings.HasPrefix(source, ".") {
+			source = "./" + source
+		}
+		pubsubc.Volumes = []string{fmt.Sprintf("%s:%s:ro", source, p.ConfigPath)}
+	}
+
+	compose := map[string]map[string]composeService{
+		"services": {
+			"pubsub": {
+				Image:   emulatorImage,
+				Command: []string{"gcloud", "beta", "emulators", "pubsub", "start", "--host-port=" + emulatorAddress},
+				Ports:   []string{fmt.Sprintf("%d:%d", *port, *port)},
+				Healthcheck: &composeHealthcheck{
+					Test:     []string{"CMD", "curl", "-f", fmt.Sprintf("http://localhost:%d", *port)},
+					Interval: "5s",
+					Retries:  12,
+				},
+			},
+			"pubsubc": pubsubc,
+		},
+	}
+
+	return writeYAML(os.Stdout, compose)
+}
+
+// writeYAML writes v as YAML with the two space indentation most
+// configuration files use.
+func writeYAML(w io.Writer, v interface{}) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("Unable to write YAML: %s", err)
+	}
+	return encoder.Close()
+}
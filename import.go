@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// topicOptionField is the field number of the pubsubc.topic message option
+// defined in proto/pubsubc/options.proto.
+const topicOptionField = 51234
+
+// importCommand runs one of the import subcommands.
+func importCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Expected a format to import: proto")
+	}
+
+	switch args[0] {
+	case "proto":
+		return importProto(args[1:])
+	default:
+		return fmt.Errorf("Unknown import format %q", args[0])
+	}
+}
+
+// importProto prints a config file with a topic for every message in a
+// FileDescriptorSet that has the pubsubc.topic option, with a protobuf schema
+// of the message named after it.
+func importProto(args []string) error {
+	flags := flag.NewFlagSet("import proto", flag.ExitOnError)
+	descriptor := flags.String("descriptor", "", "FileDescriptorSet to read, as written by protoc --descriptor_set_out")
+	projectID := flags.String("project", defaultProjectID(), "Project ID for the topics")
+	flags.Parse(args)
+
+	if *descriptor == "" || *projectID == "" {
+		return fmt.Errorf("import proto: --descriptor and --project are required")
+	}
+
+	data, err := os.ReadFile(*descriptor)
+	if err != nil {
+		return fmt.Errorf("Unable to read descriptor set %q: %s", *descriptor, err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return ErrConfigSyntax.wrapf("Unable to parse descriptor set %q: %s", *descriptor, err)
+	}
+
+	types := protoTypes(&set)
+	project := &Project{ID: *projectID}
+	var addMessages func(prefix string, messages []*descriptorpb.DescriptorProto) error
+	addMessages = func(prefix string, messages []*descriptorpb.DescriptorProto) error {
+		for _, message := range messages {
+			name := prefix + message.GetName()
+			if err := addMessages(name+".", message.GetNestedType()); err != nil {
+				return err
+			}
+
+			// The option isn't registered, so it is left in the unknown fields.
+			options := message.GetOptions()
+			if options == nil {
+				continue
+			}
+			topic, err := topicOption(options.ProtoReflect().GetUnknown())
+			if err != nil {
+				return ErrConfigSyntax.wrapf("Unable to parse the pubsubc.topic option of message %q: %s", name, err)
+			}
+			if topic == nil {
+				continue
+			}
+			if topic.ID == "" {
+				topic.ID = name
+			}
+			definition, err := protoSchema(types, "."+name)
+			if err != nil {
+				return ErrConfigInvalid.wrapf("Unable to make a schema for message %q: %s", name, err)
+			}
+			topic.Schema = &TopicSchema{ID: name, Type: "protobuf", Definition: definition}
+			debugf("  Message %q is published to topic %q", name, topic.ID)
+			project.Topics = append(project.Topics, topic)
+		}
+		return nil
+	}
+	for _, file := range set.GetFile() {
+		prefix := ""
+		if file.GetPackage() != "" {
+			prefix = file.GetPackage() + "."
+		}
+		if err := addMessages(prefix, file.GetMessageType()); err != nil {
+			return err
+		}
+	}
+
+	config := &Config{Projects: []*Project{project}}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	return writeYAML(os.Stdout, config)
+}
+
+// fields calls fn with the number, type and raw value of every field in b.
+func fields(b []byte, fn func(protowire.Number, protowire.Type, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+		if err := fn(num, typ, b[:m]); err != nil {
+			return err
+		}
+		b = b[m:]
+	}
+	return nil
+}
+
+// bytesValue decodes a length delimited field value.
+func bytesValue(typ protowire.Type, b []byte) ([]byte, error) {
+	if typ != protowire.BytesType {
+		return nil, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	v, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return nil, protowire.ParseError(n)
+	}
+	return v, nil
+}
+
+// varintValue decodes a varint field value.
+func varintValue(typ protowire.Type, b []byte) (uint64, error) {
+	if typ != protowire.VarintType {
+		return 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	v, n := protowire.ConsumeVarint(b)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	return v, nil
+}
+
+// topicOption decodes the pubsubc.topic option from the unknown fields of a
+// message's options, returning nil if it isn't set.
+func topicOption(unknown []byte) (*Topic, error) {
+	var topic *Topic
+	err := fields(unknown, func(num protowire.Number, typ protowire.Type, b []byte) error {
+		if num != topicOptionField {
+			return nil
+		}
+		v, err := bytesValue(typ, b)
+		if err != nil {
+			return err
+		}
+
+		// Repeated occurrences of a message field are merged.
+		if topic == nil {
+			topic = &Topic{}
+		}
+		return fields(v, func(num protowire.Number, typ protowire.Type, b []byte) error {
+			if num != 1 && num != 2 {
+				return nil
+			}
+			v, err := bytesValue(typ, b)
+			if err != nil {
+				return err
+			}
+			switch num {
+			case 1:
+				topic.ID = string(v)
+			case 2:
+				subscription, err := subscriptionOption(v)
+				if err != nil {
+					return err
+				}
+				topic.Subscriptions = append(topic.Subscriptions, subscription)
+			}
+			return nil
+		})
+	})
+	return topic, err
+}
+
+// subscriptionOption decodes a pubsubc.SubscriptionOptions message.
+func subscriptionOption(b []byte) (*Subscription, error) {
+	subscription := &Subscription{}
+	err := fields(b, func(num protowire.Number, typ protowire.Type, b []byte) error {
+		switch num {
+		case 1, 2:
+			v, err := bytesValue(typ, b)
+			if err != nil {
+				return err
+			}
+			if num == 1 {
+				subscription.ID = string(v)
+			} else {
+				subscription.PushEndpoint = string(v)
+			}
+		case 3, 4, 5:
+			v, err := varintValue(typ, b)
+			if err != nil {
+				return err
+			}
+			switch num {
+			case 3:
+				subscription.DeadLetter = boolPtr(v != 0)
+			case 4:
+				subscription.MessageOrdering = boolPtr(v != 0)
+			case 5:
+				ackDeadline := time.Duration(int32(v)) * time.Second
+				subscription.AckDeadline = &ackDeadline
+			}
+		}
+		return nil
+	})
+	return subscription, err
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// prompter asks questions on a terminal.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask asks the question until the answer passes check, which returns why it
+// doesn't, returning def if the answer is empty.
+func (p *prompter) ask(question, def string, check func(string) string) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(p.out, "%s: ", question)
+		}
+		line, err := p.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			fmt.Fprintln(p.out)
+			return "", fmt.Errorf("init: Unexpected end of input")
+		}
+
+		answer := strings.TrimSpace(line)
+		if answer == "" {
+			answer = def
+		}
+		if check == nil {
+			return answer, nil
+		}
+		if problem := check(answer); problem != "" {
+			fmt.Fprintf(p.out, "  %q %s\n", answer, problem)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// confirm asks a yes or no question.
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	choices := "y/N"
+	if def {
+		choices = "Y/n"
+	}
+	answer, err := p.ask(question+" ("+choices+")", "", func(answer string) string {
+		switch strings.ToLower(answer) {
+		case "", "y", "yes", "n", "no":
+			return ""
+		}
+		return "is not yes or no"
+	})
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	case "n", "no":
+		return false, nil
+	}
+	return def, nil
+}
+
+// optionalName checks an ID that may be left empty to finish a list.
+func optionalName(id string) string {
+	if id == "" {
+		return ""
+	}
+	return invalidName(id)
+}
+
+// checkEndpoint checks a push endpoint that may be left empty for a pull
+// subscription.
+func checkEndpoint(endpoint string) string {
+	if endpoint == "" {
+		return ""
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "is not an http or https URL"
+	}
+	return ""
+}
+
+// envEndpointProblem returns why a push endpoint can't be written in a
+// PUBSUB_PROJECT variable, or "" if it can.
+func envEndpointProblem(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "http" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return "must be an http URL without a path or query"
+	}
+	if d := delimiters.find(u.Hostname() + u.Port()); d != "" {
+		return fmt.Sprintf("has the delimiter %q in its host", d)
+	}
+	return ""
+}
+
+// envChecked adds to check, if not nil, the checks of --format env: IDs can't
+// contain a delimiter, and push endpoints must pass envEndpointProblem.
+func envChecked(check func(string) string, endpoint bool) func(string) string {
+	return func(answer string) string {
+		if check != nil {
+			if problem := check(answer); problem != "" {
+				return problem
+			}
+		}
+		if answer == "" {
+			return ""
+		}
+		if endpoint {
+			if problem := envEndpointProblem(answer); problem != "" {
+				return problem + " for --format env"
+			}
+		} else if d := delimiters.find(answer); d != "" {
+			return fmt.Sprintf("contains the delimiter %q, which --format env can't write", d)
+		}
+		return ""
+	}
+}
+
+// initCommand asks for the projects, topics and subscriptions to create and
+// writes them as a config file or PUBSUB_PROJECT variables.
+func initCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	output := flags.String("output", "", "File to write to instead of stdout")
+	format := flags.String("format", "yaml", "Format to write: yaml, a config file for --config, or env, PUBSUB_PROJECT variable exports")
+	flags.Parse(args)
+
+	if *format != "yaml" && *format != "env" {
+		return fmt.Errorf("init: Invalid --format %q: expected yaml or env", *format)
+	}
+
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stderr}
+	if *output != "" {
+		if _, err := os.Stat(*output); err == nil {
+			overwrite, err := p.confirm(fmt.Sprintf("%s already exists, overwrite it?", *output), false)
+			if err != nil {
+				return err
+			}
+			if !overwrite {
+				return nil
+			}
+		}
+	}
+
+	// PUBSUB_PROJECT variables can't express everything a config file can, so
+	// the answers are checked as they are given.
+	var checkProject func(string) string
+	checkName, checkPush := optionalName, checkEndpoint
+	if *format == "env" {
+		checkProject = envChecked(nil, false)
+		checkName, checkPush = envChecked(optionalName, false), envChecked(checkEndpoint, true)
+	}
+
+	config := &Config{}
+	for {
+		project := &Project{}
+		var err error
+		if project.ID, err = p.ask("Project ID", defaultProjectID(), checkProject); err != nil {
+			return err
+		}
+		if project.ID == "" {
+			continue
+		}
+
+		for {
+			topic := &Topic{}
+			if topic.ID, err = p.ask("  Topic ID, empty to finish the project", "", checkName); err != nil {
+				return err
+			}
+			if topic.ID == "" {
+				break
+			}
+
+			for {
+				subscription := &Subscription{}
+				if subscription.ID, err = p.ask(fmt.Sprintf("    Subscription ID on %q, empty to finish the topic", topic.ID), "", checkName); err != nil {
+					return err
+				}
+				if subscription.ID == "" {
+					break
+				}
+				if subscription.PushEndpoint, err = p.ask("      Push endpoint, empty for a pull subscription", "", checkPush); err != nil {
+					return err
+				}
+				// Push subscriptions are ordered, as in PUBSUB_PROJECT
+				// variables, which can only dead letter those.
+				if subscription.PushEndpoint != "" {
+					subscription.MessageOrdering = boolPtr(true)
+				}
+				if subscription.PushEndpoint != "" || *format != "env" {
+					deadLetter, err := p.confirm("      Dead letter undeliverable messages?", false)
+					if err != nil {
+						return err
+					}
+					if deadLetter {
+						subscription.DeadLetter = boolPtr(true)
+					}
+				}
+				topic.Subscriptions = append(topic.Subscriptions, subscription)
+			}
+			project.Topics = append(project.Topics, topic)
+		}
+		if len(project.Topics) == 0 {
+			fmt.Fprintln(p.out, "  A project needs at least 1 topic")
+			continue
+		}
+		config.Projects = append(config.Projects, project)
+
+		another, err := p.confirm("Add another project?", false)
+		if err != nil {
+			return err
+		}
+		if !another {
+			break
+		}
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("Unable to create %q: %s", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "env" {
+		if err := writeEnv(w, config); err != nil {
+			return err
+		}
+	} else if err := writeYAML(w, config); err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Fprintf(p.out, "Wrote %s\n", *output)
+	}
+	return nil
+}
+
+// writeEnv writes the config as shell exports of PUBSUB_PROJECT variables,
+// using the delimiters in use. It fails if the config has anything the
+// variables can't express.
+func writeEnv(w io.Writer, config *Config) error {
+	for i, project := range config.Projects {
+		if d := delimiters.find(project.ID); d != "" {
+			return fmt.Errorf("init: Unable to write project %q as a PUBSUB_PROJECT variable: it contains the delimiter %q; use --format yaml", project.ID, d)
+		}
+		definition := project.ID
+		for _, topic := range project.Topics {
+			if d := delimiters.find(topic.ID); d != "" {
+				return fmt.Errorf("init: Unable to write topic %q as a PUBSUB_PROJECT variable: it contains the delimiter %q; use --format yaml", topic.ID, d)
+			}
+			definition += delimiters.Topic + topic.ID
+			for _, subscription := range topic.Subscriptions {
+				s, err := subscriptionDefinition(subscription)
+				if err != nil {
+					return fmt.Errorf("init: Unable to write subscription %q as a PUBSUB_PROJECT variable: %s; use --format yaml", subscription.ID, err)
+				}
+				definition += delimiters.Subscription + s
+			}
+		}
+		fmt.Fprintf(w, "export PUBSUB_PROJECT%d='%s'\n", i+1, strings.ReplaceAll(definition, "'", `'\''`))
+	}
+	return nil
+}
+
+// subscriptionDefinition returns the subscription in the form parseSubscription
+// accepts.
+func subscriptionDefinition(subscription *Subscription) (string, error) {
+	if d := delimiters.find(subscription.ID); d != "" {
+		return "", fmt.Errorf("it contains the delimiter %q", d)
+	}
+	if subscription.PushEndpoint == "" {
+		if subscription.deadLetters() {
+			return "", fmt.Errorf("dead lettering needs a push endpoint")
+		}
+		return subscription.ID, nil
+	}
+
+	if problem := envEndpointProblem(subscription.PushEndpoint); problem != "" {
+		return "", fmt.Errorf("the push endpoint %s", problem)
+	}
+	u, _ := url.Parse(subscription.PushEndpoint)
+	definition := subscription.ID + delimiters.Push + u.Hostname()
+	if u.Port() != "" {
+		definition += delimiters.Port + u.Port()
+	}
+	if subscription.deadLetters() {
+		definition += delimiters.Push + "dlq"
+	}
+	return definition, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+)
+
+// testCase is the outcome of one check, reported as a JUnit test case.
+type testCase struct {
+	// Class groups the check, usually by project, and Name is the resource
+	// checked.
+	Class   string
+	Name    string
+	Elapsed time.Duration
+	// Failure describes why the check failed, and Skipped why it wasn't
+	// made, if either is the case.
+	Failure string
+	Skipped string
+}
+
+// The subset of the JUnit XML format that CI systems read.
+type (
+	junitSuites struct {
+		XMLName xml.Name     `xml:"testsuites"`
+		Suites  []junitSuite `xml:"testsuite"`
+	}
+
+	junitSuite struct {
+		Name     string      `xml:"name,attr"`
+		Tests    int         `xml:"tests,attr"`
+		Failures int         `xml:"failures,attr"`
+		Skipped  int         `xml:"skipped,attr"`
+		Time     float64     `xml:"time,attr"`
+		Cases    []junitCase `xml:"testcase"`
+	}
+
+	junitCase struct {
+		Class   string        `xml:"classname,attr"`
+		Name    string        `xml:"name,attr"`
+		Time    float64       `xml:"time,attr"`
+		Failure *junitMessage `xml:"failure,omitempty"`
+		Skipped *junitMessage `xml:"skipped,omitempty"`
+	}
+
+	junitMessage struct {
+		Message string `xml:"message,attr"`
+	}
+)
+
+// writeJUnit writes the test cases to path as a JUnit XML report with a
+// single suite.
+func writeJUnit(path, suite string, cases []testCase) error {
+	s := junitSuite{Name: suite, Tests: len(cases)}
+	for _, c := range cases {
+		jc := junitCase{Class: c.Class, Name: c.Name, Time: c.Elapsed.Seconds()}
+		if c.Failure != "" {
+			jc.Failure = &junitMessage{Message: c.Failure}
+			s.Failures++
+		}
+		if c.Skipped != "" {
+			jc.Skipped = &junitMessage{Message: c.Skipped}
+			s.Skipped++
+		}
+		s.Time += jc.Time
+		s.Cases = append(s.Cases, jc)
+	}
+
+	data, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{s}}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Unable to encode JUnit report: %s", err)
+	}
+	if err := os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644); err != nil {
+		return fmt.Errorf("Unable to write JUnit report %q: %s", path, err)
+	}
+	return nil
+}
+
+// reportCases prints the test cases, writes them to junitFile if it is set,
+// and returns err as the outcome of the checks.
+func reportCases(junitFile, suite string, cases []testCase, err error) error {
+	for _, c := range cases {
+		switch {
+		case c.Failure != "":
+			fmt.Fprintf(logOutput, "FAIL %s: %s\n", c.Name, c.Failure)
+		case c.Skipped != "":
+			fmt.Fprintf(logOutput, "SKIP %s: %s\n", c.Name, c.Skipped)
+		default:
+			fmt.Fprintf(logOutput, "ok   %s\n", c.Name)
+		}
+	}
+	if junitFile != "" {
+		if writeErr := writeJUnit(junitFile, suite, cases); writeErr != nil {
+			return writeErr
+		}
+	}
+	return err
+}
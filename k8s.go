@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The subset of Kubernetes objects that generate k8s writes.
+type (
+	k8sMetadata struct {
+		Name   string            `yaml:"name"`
+		Labels map[string]string `yaml:"labels,omitempty"`
+	}
+
+	k8sConfigMap struct {
+		APIVersion string            `yaml:"apiVersion"`
+		Kind       string            `yaml:"kind"`
+		Metadata   k8sMetadata       `yaml:"metadata"`
+		Data       map[string]string `yaml:"data"`
+	}
+
+	k8sJob struct {
+		APIVersion string      `yaml:"apiVersion"`
+		Kind       string      `yaml:"kind"`
+		Metadata   k8sMetadata `yaml:"metadata"`
+		Spec       k8sJobSpec  `yaml:"spec"`
+	}
+
+	k8sJobSpec struct {
+		BackoffLimit int         `yaml:"backoffLimit"`
+		Template     k8sTemplate `yaml:"template"`
+	}
+
+	k8sTemplate struct {
+		Metadata k8sMetadata `yaml:"metadata"`
+		Spec     k8sPodSpec  `yaml:"spec"`
+	}
+
+	k8sPodSpec struct {
+		RestartPolicy string         `yaml:"restartPolicy"`
+		Containers    []k8sContainer `yaml:"containers"`
+		Volumes       []k8sVolume    `yaml:"volumes,omitempty"`
+	}
+
+	k8sContainer struct {
+		Name         string           `yaml:"name"`
+		Image        string           `yaml:"image"`
+		Args         []string         `yaml:"args,omitempty"`
+		Env          []k8sEnv         `yaml:"env"`
+		VolumeMounts []k8sVolumeMount `yaml:"volumeMounts,omitempty"`
+	}
+
+	k8sEnv struct {
+		Name      string        `yaml:"name"`
+		Value     string        `yaml:"value,omitempty"`
+		ValueFrom *k8sEnvSource `yaml:"valueFrom,omitempty"`
+	}
+
+	k8sEnvSource struct {
+		ConfigMapKeyRef k8sKeyRef `yaml:"configMapKeyRef"`
+	}
+
+	k8sKeyRef struct {
+		Name string `yaml:"name"`
+		Key  string `yaml:"key"`
+	}
+
+	k8sVolume struct {
+		Name      string          `yaml:"name"`
+		ConfigMap k8sConfigMapRef `yaml:"configMap"`
+	}
+
+	k8sConfigMapRef struct {
+		Name  string         `yaml:"name"`
+		Items []k8sKeyToPath `yaml:"items"`
+	}
+
+	k8sKeyToPath struct {
+		Key  string `yaml:"key"`
+		Path string `yaml:"path"`
+	}
+
+	k8sVolumeMount struct {
+		Name      string `yaml:"name"`
+		MountPath string `yaml:"mountPath"`
+		ReadOnly  bool   `yaml:"readOnly"`
+	}
+)
+
+// generateK8s writes a ConfigMap holding the current config, a Job that runs
+// pubsubc with it against an in-cluster emulator, and the same container as an
+// init container for adding to a workload.
+func generateK8s(args []string) error {
+	flags := flag.NewFlagSet("generate k8s", flag.ExitOnError)
+	out := flags.String("out", ".", "Directory to write the manifests to")
+	name := flags.String("name", "pubsubc", "Name of the ConfigMap and Job")
+	image := flags.String("image", "pubsubc", "Image to run pubsubc from")
+	emulatorHost := flags.String("emulator-host", "pubsub-emulator:8085", "Host and port of the in-cluster emulator service")
+	flags.Parse(args)
+
+	const configDir = "/etc/pubsubc"
+	p, err := currentProvisioning(configDir)
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]string{"app.kubernetes.io/name": "pubsubc", "app.kubernetes.io/instance": *name}
+	configMap := k8sConfigMap{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata:   k8sMetadata{Name: *name, Labels: labels},
+		Data:       make(map[string]string),
+	}
+
+	container := k8sContainer{
+		Name:  "pubsubc",
+		Image: *image,
+		Args:  p.Args,
+		Env:   []k8sEnv{{Name: "PUBSUB_EMULATOR_HOST", Value: *emulatorHost}},
+	}
+
+	keys := make([]string, 0, len(p.Environment))
+	for key := range p.Environment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		configMap.Data[key] = p.Environment[key]
+		container.Env = append(container.Env, k8sEnv{
+			Name:      key,
+			ValueFrom: &k8sEnvSource{ConfigMapKeyRef: k8sKeyRef{Name: *name, Key: key}},
+		})
+	}
+
+	var volumes []k8sVolume
+	if p.ConfigFile != "" {
+		data, err := os.ReadFile(p.ConfigFile)
+		if err != nil {
+			return fmt.Errorf("Unable to read config file %q: %s", p.ConfigFile, err)
+		}
+		key := filepath.Base(p.ConfigFile)
+		configMap.Data[key] = string(data)
+
+		volumes = []k8sVolume{{
+			Name:      "config",
+			ConfigMap: k8sConfigMapRef{Name: *name, Items: []k8sKeyToPath{{Key: key, Path: key}}},
+		}}
+		container.VolumeMounts = []k8sVolumeMount{{Name: "config", MountPath: configDir, ReadOnly: true}}
+	}
+
+	job := k8sJob{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Metadata:   k8sMetadata{Name: *name, Labels: labels},
+		Spec: k8sJobSpec{
+			BackoffLimit: 3,
+			Template: k8sTemplate{
+				Metadata: k8sMetadata{Name: *name, Labels: labels},
+				Spec: k8sPodSpec{
+					RestartPolicy: "OnFailure",
+					Containers:    []k8sContainer{container},
+					Volumes:       volumes,
+				},
+			},
+		},
+	}
+
+	// The init container is a fragment for a pod's initContainers, along
+	// with the volumes it needs.
+	initContainer := struct {
+		InitContainers []k8sContainer `yaml:"initContainers"`
+		Volumes        []k8sVolume    `yaml:"volumes,omitempty"`
+	}{[]k8sContainer{container}, volumes}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return fmt.Errorf("Unable to create directory %q: %s", *out, err)
+	}
+	manifests := []struct {
+		file string
+		v    interface{}
+	}{
+		{"configmap.yaml", configMap},
+		{"job.yaml", job},
+		{"init-container.yaml", initContainer},
+	}
+	for _, m := range manifests {
+		path := filepath.Join(*out, m.file)
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("Unable to create %q: %s", path, err)
+		}
+		err = writeYAML(f, m.v)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to write %q: %s", path, err)
+		}
+		debugf("Wrote %q", path)
+	}
+
+	return nil
+}
@@ -4,36 +4,110 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 var (
-	debug   = flag.Bool("debug", false, "Enable debug logging")
-	help    = flag.Bool("help", false, "Display usage information")
-	version = flag.Bool("version", false, "Display version information")
+	auditLog           = flag.String("audit-log", "", "Append a record of every operation to this file")
+	cacheFile          = flag.String("cache-file", "", "Skip creating anything if the config is unchanged since it was last applied, as recorded in this file")
+	branch             = flag.String("branch", "", "Branch for {{.Branch}} in names, defaulting to the CI branch")
+	buildID            = flag.String("build-id", "", "Build ID for {{.BuildID}} in names, defaulting to the CI build ID")
+	connectTimeout     = flag.Duration("connect-timeout", 10*time.Second, "How long to wait for a connection to the emulator before failing")
+	configFile         = flag.String("config", "", "YAML or CSV file describing projects to create in addition to PUBSUB_PROJECT variables")
+	endpointCheck      = flag.String("check-endpoints", "skip", "Probe push endpoints before creating subscriptions: warn, fail or skip")
+	credentialsFile    = flag.String("credentials-file", "", "Credentials for GCP: a service account key or workload identity federation config")
+	debug              = flag.Bool("debug", false, "Enable debug logging")
+	discover           = flag.Bool("discover-emulator", false, "Look for an emulator on common local hosts if PUBSUB_EMULATOR_HOST is not set")
+	delimiterList      = flag.String("delimiters", os.Getenv("PUBSUBC_DELIMITERS"), "Replace the \",:+|\" delimiters of PUBSUB_PROJECT variables, in that order")
+	events             = flag.String("events", "", "Write a machine readable event stream in this format: ndjson")
+	eventsFile         = flag.String("events-file", "", "Write the event stream to this file instead of stdout")
+	notifyURL          = flag.String("notify-url", "", "POST the summary of the run to this URL when provisioning finishes")
+	notifyFormat       = flag.String("notify-format", "json", "Format of the --notify-url payload: json, the summary event, or slack")
+	projectsFile       = flag.String("projects-file", "", "File of project definitions, one per line in the PUBSUB_PROJECT format, to create in addition to PUBSUB_PROJECT variables")
+	proxy              = flag.String("proxy", "", "Proxy URL for connections to GCP, overriding HTTPS_PROXY")
+	failIfExists       = flag.Bool("fail-if-exists", false, "Fail before creating anything if any declared resource already exists")
+	force              = flag.Bool("force", false, "Apply the config even if --cache-file records it as unchanged")
+	help               = flag.Bool("help", false, "Display usage information")
+	impersonateAccount = flag.String("impersonate-service-account", "", "Impersonate this service account when connecting to GCP")
+	maxSubscriptions   = flag.Int("max-subscriptions", 0, "Fail before creating anything if the config has more subscriptions than this, 0 for no limit")
+	maxTopics          = flag.Int("max-topics", 0, "Fail before creating anything if the config has more topics than this, 0 for no limit")
+	maxReceiveBytes    = flag.Int("max-receive-message-bytes", 0, "Largest gRPC message the clients accept, 0 for the client library default")
+	maxSendBytes       = flag.Int("max-send-message-bytes", 0, "Largest gRPC message the clients send, 0 for the client library default")
+	onConflict         = flag.String("on-conflict", "error", "What to do when a declared resource already exists: error, skip, update or suffix it with the run ID")
+	nameUser           = flag.String("user", "", "User for {{.User}} in names, defaulting to the CI user or $USER")
+	runIDFlag          = flag.String("run-id", "", "ID of this run for {{.RunID}} in names and the pubsubc-run-id label, generated if not set")
+	tagList            = flag.String("tags", "", "Only create the topics and subscriptions with one of these comma separated tags")
+	version            = flag.Bool("version", false, "Display version information")
+	waitEndpoints      = flag.Duration("wait-for-endpoints", 0, "Wait up to this long for each push endpoint to accept connections before creating its subscription")
 )
 
+// hostList holds the values of a repeated flag.
+type hostList []string
+
+func (l *hostList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *hostList) Set(s string) error {
+	for _, host := range *l {
+		if host == s {
+			return fmt.Errorf("%q is given more than once", s)
+		}
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+// emulatorHosts are the emulators given with --emulator-host, which each get
+// every project.
+var emulatorHosts hostList
+
+func init() {
+	flag.Var(&emulatorHosts, "emulator-host", "Create every project on this emulator instead of PUBSUB_EMULATOR_HOST; repeat to keep several emulators identical")
+}
+
 // The CommitHash and Revision variables are set during building.
 var (
 	CommitHash = "<not set>"
 	Revision   = "<not set>"
 )
 
-// Topics describes a PubSub topic and its subscriptions.
-type Topics map[string][]string
+// topicName returns the full resource name of a topic.
+func topicName(projectID, topicID string) string {
+	return fmt.Sprintf("projects/%s/topics/%s", projectID, topicID)
+}
+
+// subscriptionName returns the full resource name of a subscription.
+func subscriptionName(projectID, subscriptionID string) string {
+	return fmt.Sprintf("projects/%s/subscriptions/%s", projectID, subscriptionID)
+}
 
 func versionString() string {
 	return fmt.Sprintf("pubsubc - build %s (%s) running on %s", Revision, CommitHash, runtime.Version())
 }
 
+// logOutput receives debugging information. It is stderr when the event
+// stream is written to stdout.
+var logOutput io.Writer = os.Stdout
+
 // debugf prints debugging information.
 func debugf(format string, params ...interface{}) {
 	if *debug {
-		fmt.Printf(format+"\n", params...)
+		fmt.Fprintf(logOutput, format+"\n", params...)
 	}
 }
 
@@ -43,93 +117,370 @@ func fatalf(format string, params ...interface{}) {
 	os.Exit(1)
 }
 
-// create a connection to the PubSub service and create topics and subscriptions
-// for the specified project ID.
-func create(ctx context.Context, projectID string, topics Topics) error {
-	client, err := pubsub.NewClient(ctx, projectID)
+// newClient creates a PubSub client for the project. If emulatorHost is set it
+// is used instead of PUBSUB_EMULATOR_HOST.
+func newClient(ctx context.Context, projectID, emulatorHost string) (*pubsub.Client, error) {
+	opts, err := clientOptions(ctx, emulatorHost)
+	if err != nil {
+		return nil, err
+	}
+	return pubsub.NewClient(ctx, projectID, opts...)
+}
+
+// clientOptions returns the options for connecting to the emulator at
+// emulatorHost, or PUBSUB_EMULATOR_HOST if it is empty, or else to GCP.
+// Connections to an emulator never go through a proxy; connections to GCP
+// honor HTTPS_PROXY and NO_PROXY.
+func clientOptions(ctx context.Context, emulatorHost string) ([]option.ClientOption, error) {
+	if emulatorHost == "" {
+		emulatorHost = os.Getenv("PUBSUB_EMULATOR_HOST")
+	}
+	if emulatorHost == "" {
+		opts, err := credentialOptions(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, opt := range messageSizeOptions() {
+			opts = append(opts, option.WithGRPCDialOption(opt))
+		}
+		return opts, nil
+	}
+
+	dialOptions := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithNoProxy()}, messageSizeOptions()...)
+	conn, err := grpc.Dial(emulatorHost, dialOptions...)
 	if err != nil {
-		return fmt.Errorf("Unable to create client to project %q: %s", projectID, err)
+		return nil, err
+	}
+	if err := waitForConnection(ctx, conn, emulatorHost); err != nil {
+		conn.Close()
+		return nil, err
 	}
-	defer client.Close()
+	return []option.ClientOption{option.WithGRPCConn(conn), option.WithTelemetryDisabled()}, nil
+}
 
-	debugf("Client connected with project ID %q", projectID)
+// messageSizeOptions returns the dial options applying --max-send-message-bytes
+// and --max-receive-message-bytes. They are added to every call, after the
+// options the client library gives its publish and pull calls, so that they
+// take precedence.
+func messageSizeOptions() []grpc.DialOption {
+	var callOptions []grpc.CallOption
+	if *maxSendBytes > 0 {
+		callOptions = append(callOptions, grpc.MaxCallSendMsgSize(*maxSendBytes))
+	}
+	if *maxReceiveBytes > 0 {
+		callOptions = append(callOptions, grpc.MaxCallRecvMsgSize(*maxReceiveBytes))
+	}
+	if len(callOptions) == 0 {
+		return nil
+	}
 
-	for topicID, subscriptions := range topics {
-		debugf("  Creating topic %q", topicID)
-		topic, err := client.CreateTopic(ctx, topicID)
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(ctx, method, req, reply, cc, append(opts, callOptions...)...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(ctx, desc, cc, method, append(opts, callOptions...)...)
+		}),
+	}
+}
+
+// create connects to the PubSub service for each project and creates the
+// topics of every project, including dead letter ones, before any of their
+// subscriptions, so that subscriptions can dead letter to a topic declared
+// anywhere in the config. A project with an emulator host is created on that
+// emulator rather than the one in PUBSUB_EMULATOR_HOST.
+func create(ctx context.Context, projects []*Project) error {
+	clients := make([]*pubsub.Client, 0, len(projects))
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+
+	topics := make([]map[string]*pubsub.Topic, len(projects))
+	for i, project := range projects {
+		client, err := newClient(ctx, project.ID, project.EmulatorHost)
 		if err != nil {
-			return fmt.Errorf("Unable to create topic %q for project %q: %s", topicID, projectID, err)
+			return apiErrorf(err, "Unable to create client to project %q", project.ID)
 		}
+		clients = append(clients, client)
 
-		for _, subscription := range subscriptions {
-			subscriptionParts := strings.Split(subscription, "+")
-			subscriptionID := subscriptionParts[0]
-			if len(subscriptionParts) > 1 {
-				pushEndpoint := strings.Replace(subscriptionParts[1], "|", ":", 1)
-				debugf("    Creating push subscription %q with target %q", subscriptionID, pushEndpoint)
-				pushConfig := pubsub.PushConfig{Endpoint: "http://" + pushEndpoint}
-				var deadLetterPolicy *pubsub.DeadLetterPolicy
+		if project.EmulatorHost != "" {
+			debugf("Client connected with project ID %q on emulator %q", project.ID, project.EmulatorHost)
+		} else {
+			debugf("Client connected with project ID %q", project.ID)
+		}
 
-				if len(subscriptionParts) == 3 && subscriptionParts[2] == "dlq" {
-					dlqTopicID := fmt.Sprintf("%s-dlq", topicID)
-					debugf("      Creating DLQ topic %q", dlqTopicID)
+		if err := checkEndpoints(ctx, project, *endpointCheck); err != nil {
+			return err
+		}
+		if topics[i], err = createTopics(ctx, client, project); err != nil {
+			return err
+		}
+	}
 
-					dlqTopic, err := client.CreateTopic(ctx, dlqTopicID)
-					if err != nil {
-						return fmt.Errorf("      Unable to create dead letter topic for topic %q for project %q: %s", topicID, projectID, err)
-					}
+	for i, project := range projects {
+		if err := createSubscriptions(ctx, clients[i], project, topics[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createTopics creates the topics of the project and the dead letter topics of
+// those with dead lettering subscriptions, returning them by declared ID.
+func createTopics(ctx context.Context, client *pubsub.Client, project *Project) (map[string]*pubsub.Topic, error) {
+	projectID := project.ID
+	topics := make(map[string]*pubsub.Topic)
 
-					dlqSubscriptionID := fmt.Sprintf("%s-dlq", subscriptionID)
-
-					_, err = client.CreateSubscription(
-						ctx,
-						dlqSubscriptionID,
-						pubsub.SubscriptionConfig{
-							Topic:                 dlqTopic,
-							PushConfig:            pubsub.PushConfig{Endpoint: fmt.Sprintf("http://%s/dead", pushEndpoint)},
-							EnableMessageOrdering: true,
-						},
-					)
+	// Topics sharing a schema create it once.
+	var schemaClient *pubsub.SchemaClient
+	defer func() {
+		if schemaClient != nil {
+			schemaClient.Close()
+		}
+	}()
+	schemas := make(map[string]string)
+
+	for _, t := range project.Topics {
+		topicID := t.ID
+		var schema *pubsub.SchemaSettings
+		if t.Schema != nil {
+			name, ok := schemas[t.Schema.ID]
+			if !ok {
+				var err error
+				if schemaClient == nil {
+					schemaClient, err = newSchemaClient(ctx, projectID, project.EmulatorHost)
 					if err != nil {
-						return fmt.Errorf("      Unable to create dead letter subscription for topic %q for project %q: %s", dlqTopicID, projectID, err)
+						return nil, apiErrorf(err, "Unable to create schema client to project %q", projectID)
 					}
+				}
+				debugf("  Creating %s schema %q", t.Schema.Type, t.Schema.ID)
+				name, err = createTopicSchema(ctx, schemaClient, projectID, t.Schema)
+				if err != nil {
+					return nil, apiErrorf(err, "Unable to create schema %q for topic %q for project %q", t.Schema.ID, topicID, projectID)
+				}
+				schemas[t.Schema.ID] = name
+			}
+			schema = t.Schema.settings(name)
+		}
+
+		debugf("  Creating topic %q", topicID)
+		topic, err := createTopic(ctx, client, project, topicID, t.Labels, schema)
+		if err != nil {
+			return nil, apiErrorf(err, "Unable to create topic %q for project %q", topicID, projectID)
+		}
+		topics[topicID] = topic
+
+		// The dead letter topic is shared by all subscriptions of the topic.
+		for _, subscription := range t.Subscriptions {
+			if !subscription.ownDeadLetters() {
+				continue
+			}
+			dlqTopicID := t.deadLetterTopicID()
+			debugf("      Creating DLQ topic %q", dlqTopicID)
+			dlqTopic, err := createTopic(ctx, client, project, dlqTopicID, nil, nil)
+			if err != nil {
+				return nil, apiErrorf(err, "      Unable to create dead letter topic for topic %q for project %q", topicID, projectID)
+			}
+			topics[dlqTopicID] = dlqTopic
+			break
+		}
+	}
+	return topics, nil
+}
+
+// createSubscriptions creates the subscriptions of the project, and their dead
+// letter subscriptions, on the topics createTopics created.
+func createSubscriptions(ctx context.Context, client *pubsub.Client, project *Project, topics map[string]*pubsub.Topic) error {
+	projectID := project.ID
+	for _, t := range project.Topics {
+		topicID := t.ID
+		topic := topics[topicID]
 
-					deadLetterPolicy = &pubsub.DeadLetterPolicy{
-						DeadLetterTopic:     dlqTopic.String(),
-						MaxDeliveryAttempts: 5, // The default value set by GCP
+		for _, subscription := range t.Subscriptions {
+			subscriptionID := subscription.ID
+			pushEndpoint := subscription.PushEndpoint
+			config := subscription.config(topic)
+
+			if subscription.ownDeadLetters() {
+				dlqTopicID := t.deadLetterTopicID()
+				dlqTopic := topics[dlqTopicID]
+
+				dlqSubscriptionID := subscription.deadLetterSubscriptionID()
+				dlqConfig := pubsub.SubscriptionConfig{
+					Topic:                 dlqTopic,
+					EnableMessageOrdering: config.EnableMessageOrdering,
+					Labels:                runLabels(nil),
+				}
+				if pushEndpoint != "" {
+					dlqConfig.PushConfig = newPushConfig(fmt.Sprintf("%s/dead", pushEndpoint), subscription.PushAuth)
+				}
+
+				err := createSubscription(ctx, client, projectID, dlqSubscriptionID, dlqConfig)
+				if err != nil {
+					return apiErrorf(err, "      Unable to create dead letter subscription for topic %q for project %q", dlqTopicID, projectID)
+				}
+
+				config.DeadLetterPolicy = &pubsub.DeadLetterPolicy{
+					DeadLetterTopic:     dlqTopic.String(),
+					MaxDeliveryAttempts: subscription.deadLetterMaxAttempts(),
+				}
+				debugf("      The topic %q on project %q has a dead letter policy", topicID, projectID)
+			} else if subscription.deadLetters() {
+				config.DeadLetterPolicy = &pubsub.DeadLetterPolicy{
+					DeadLetterTopic:     renamedTopic(project.EmulatorHost, subscription.DeadLetterTopic),
+					MaxDeliveryAttempts: subscription.deadLetterMaxAttempts(),
+				}
+				debugf("      The subscription %q dead letters to %q", subscriptionID, subscription.DeadLetterTopic)
+			}
+
+			if pushEndpoint != "" {
+				if *waitEndpoints > 0 {
+					if err := waitForEndpoint(ctx, pushEndpoint, *waitEndpoints); err != nil {
+						return fmt.Errorf("Unable to create push subscription %q on topic %q for project %q: %w", subscriptionID, topicID, projectID, err)
 					}
-					debugf("      The topic %q on project %q has a dead letter policy", topicID, projectID)
 				}
 
-				_, err = client.CreateSubscription(
-					ctx,
-					subscriptionID,
-					pubsub.SubscriptionConfig{
-						Topic:                 topic,
-						PushConfig:            pushConfig,
-						DeadLetterPolicy:      deadLetterPolicy,
-						EnableMessageOrdering: true,
-					},
-				)
+				debugf("    Creating push subscription %q with target %q", subscriptionID, pushEndpoint)
+				err := createSubscription(ctx, client, projectID, subscriptionID, config)
+				if status.Code(err) == codes.InvalidArgument {
+					return ErrBadPushEndpoint.wrapf("Unable to create push subscription %q on topic %q for project %q using push endpoint %q: %s", subscriptionID, topicID, projectID, pushEndpoint, err)
+				}
 				if err != nil {
-					return fmt.Errorf("Unable to create push subscription %q on topic %q for project %q using push endpoint %q: %s", subscriptionID, topicID, projectID, pushEndpoint, err)
+					return apiErrorf(err, "Unable to create push subscription %q on topic %q for project %q using push endpoint %q", subscriptionID, topicID, projectID, pushEndpoint)
 				}
 			} else {
-				_, err = client.CreateSubscription(ctx, subscriptionID, pubsub.SubscriptionConfig{Topic: topic})
+				debugf("    Creating subscription %q", subscriptionID)
+				err := createSubscription(ctx, client, projectID, subscriptionID, config)
 				if err != nil {
-					return fmt.Errorf("Unable to create subscription %q on topic %q for project %q: %s", subscriptionID, topicID, projectID, err)
+					return apiErrorf(err, "Unable to create subscription %q on topic %q for project %q", subscriptionID, topicID, projectID)
 				}
 			}
 		}
 	}
+	return nil
+}
 
+// provision creates every project of the config, in parallel on each
+// --emulator-host if any are given, reporting the outcome for each host.
+func provision(ctx context.Context, config *Config) error {
+	if len(emulatorHosts) == 0 {
+		return create(ctx, config.Projects)
+	}
+
+	errs := make([]error, len(emulatorHosts))
+	var wg sync.WaitGroup
+	for i, host := range emulatorHosts {
+		var projects []*Project
+		for _, project := range config.Projects {
+			if project.EmulatorHost == host {
+				projects = append(projects, project)
+			}
+		}
+
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			start := time.Now()
+			if errs[i] = create(ctx, projects); errs[i] != nil {
+				fmt.Fprintf(logOutput, "Failed to provision emulator %q: %s\n", host, errs[i])
+			} else {
+				fmt.Fprintf(logOutput, "Provisioned emulator %q in %s\n", host, time.Since(start).Round(time.Millisecond))
+			}
+		}(i, host)
+	}
+	wg.Wait()
+
+	var first error
+	failures := 0
+	for _, err := range errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failures++
+		}
+	}
+	if first != nil {
+		return fmt.Errorf("Unable to provision %d of %d emulators, first with: %w", failures, len(emulatorHosts), first)
+	}
 	return nil
 }
 
+// commands holds the subcommands that can be run instead of the default
+// provisioning, keyed by name.
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"bench":            benchCommand,
+	"backup":           backupCommand,
+	"copy":             copyCommand,
+	"destroy":          destroyCommand,
+	"dlq":              dlqCommand,
+	"explain":          explainCommand,
+	"forward":          forwardCommand,
+	"init":             initCommand,
+	"metrics-exporter": metricsCommand,
+	"mirror":           mirrorCommand,
+	"mock-push":        mockPushCommand,
+	"publish":          publishCommand,
+	"restore":          restoreCommand,
+	"search":           searchCommand,
+	"schema":           schemaCommand,
+	"smoke":            smokeCommand,
+	"subscription":     subscriptionCommand,
+	"topic":            topicCommand,
+	"verify":           verifyCommand,
+	"generate":         generateCommand,
+	"import":           importCommand,
+}
+
+// emulatorHostCommands are the subcommands that run on every --emulator-host.
+// The others would ignore it and use PUBSUB_EMULATOR_HOST, or the real
+// Pub/Sub without it, so they fail if it is given.
+var emulatorHostCommands = map[string]bool{
+	"backup":           true,
+	"destroy":          true,
+	"dlq":              true,
+	"explain":          true,
+	"metrics-exporter": true,
+	"restore":          true,
+	"smoke":            true,
+	"verify":           true,
+}
+
+// defaultProjectID returns the project ID of the first PUBSUB_PROJECT
+// environment variable, for use by commands that take an optional project.
+func defaultProjectID() string {
+	return strings.Split(os.Getenv("PUBSUB_PROJECT1"), delimiters.Topic)[0]
+}
+
 func main() {
 	flag.Parse()
 	flag.Usage = func() {
 		fmt.Printf(`Usage: env PUBSUB_PROJECT1="project1,topic1,topic2:subscription1,topic3:subscription2+enpoint1" %s`+"\n", os.Args[0])
+		fmt.Printf("       %s --config pubsubc.yaml\n", os.Args[0])
+		fmt.Printf("       %s [flags] init [--output pubsubc.yaml] [--format yaml|env]\n", os.Args[0])
+		fmt.Printf("       %s [flags] copy --from project --to project [--prefix prefix]\n", os.Args[0])
+		fmt.Printf("       %s [flags] mirror --from projects/project/topics/topic --to projects/project/topics/topic\n", os.Args[0])
+		fmt.Printf("       %s [flags] dlq watch [--project project] [--webhook url]\n", os.Args[0])
+		fmt.Printf("       %s [flags] explain\n", os.Args[0])
+		fmt.Printf("       %s [flags] verify|smoke [--junit report.xml]\n", os.Args[0])
+		fmt.Printf("       %s [flags] destroy --run-id id [--project project]\n", os.Args[0])
+		fmt.Printf("       %s [flags] backup -o env.tar.gz\n", os.Args[0])
+		fmt.Printf("       %s [flags] restore env.tar.gz\n", os.Args[0])
+		fmt.Printf("       %s [flags] generate compose|k8s\n", os.Args[0])
+		fmt.Printf("       %s [flags] import proto --descriptor set.pb\n", os.Args[0])
+		fmt.Printf("       %s [flags] publish --topic topic [--file messages.jsonl] [--compress gzip]\n", os.Args[0])
+		fmt.Printf("       %s [flags] search --subscription subscription --attr key=value [--no-ack]\n", os.Args[0])
+		fmt.Printf("       %s [flags] schema create|list|describe|delete\n", os.Args[0])
+		fmt.Printf("       %s [flags] topic update --topic topic --schema schema [--encoding binary|json]\n", os.Args[0])
+		fmt.Printf("       %s [flags] subscription set-push --subscription subscription --endpoint url\n", os.Args[0])
+		fmt.Printf("       %s [flags] subscription set-pull --subscription subscription\n", os.Args[0])
+		fmt.Printf("       %s [flags] forward --subscription subscription --endpoint url [--inject-delay 500ms] [--inject-error-rate 0.05]\n", os.Args[0])
+		fmt.Printf("       %s [flags] mock-push [--port 8080] [--inject-delay 500ms] [--inject-error-rate 0.05] [--endpoint-fault /path=500ms,0.05]\n", os.Args[0])
+		fmt.Printf("       %s [flags] metrics-exporter [--port 9102] [--interval 15s]\n", os.Args[0])
+		fmt.Printf("       %s [flags] bench roundtrip --topic topic --subscription subscription\n", os.Args[0])
+		fmt.Printf("       %s [flags] bench soak --topic topic [--consume --subscription subscription] --duration 2h --publish-rate 200/s\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
@@ -143,37 +494,152 @@ func main() {
 		return
 	}
 
-	// Cycle over the numbered PUBSUB_PROJECT environment variables.
-	for i := 1; ; i++ {
-		// Fetch the enviroment variable. If it doesn't exist, break out.
-		currentEnv := fmt.Sprintf("PUBSUB_PROJECT%d", i)
-		env := os.Getenv(currentEnv)
-		if env == "" {
-			// If this is the first environment variable, print the usage info.
-			if i == 1 {
-				flag.Usage()
-				os.Exit(1)
-			}
+	switch *endpointCheck {
+	case "warn", "fail", "skip":
+	default:
+		fatalf("Invalid --check-endpoints %q: expected warn, fail or skip", *endpointCheck)
+	}
 
-			break
+	switch *onConflict {
+	case "error", "skip", "update", "suffix":
+	default:
+		fatalf("Invalid --on-conflict %q: expected error, skip, update or suffix", *onConflict)
+	}
+	if *failIfExists && *onConflict != "error" {
+		fatalf("--fail-if-exists can't be used with --on-conflict %s", *onConflict)
+	}
+
+	switch *events {
+	case "":
+	case "ndjson":
+		if err := openEvents(*eventsFile); err != nil {
+			fatal(err)
+		}
+		if *eventsFile == "" {
+			logOutput = os.Stderr
 		}
+	default:
+		fatalf("Invalid --events %q: expected ndjson", *events)
+	}
+
+	if *notifyFormat != "json" && *notifyFormat != "slack" {
+		fatalf("Invalid --notify-format %q: expected json or slack", *notifyFormat)
+	}
 
-		// Separate the projectID from the topic definitions.
-		parts := strings.Split(env, ",")
-		if len(parts) < 2 {
-			fatalf("%s: Expected at least 1 topic to be defined", currentEnv)
+	if *delimiterList != "" {
+		d, err := parseDelimiters(*delimiterList)
+		if err != nil {
+			fatal(err)
 		}
+		delimiters = d
+	}
+
+	if err := setRunID(*runIDFlag); err != nil {
+		fatal(err)
+	}
+	debugf("Run ID %q", runID)
+
+	if *tagList != "" {
+		selectedTags = strings.Split(*tagList, ",")
+	}
+
+	// The gRPC and OAuth2 transports both read the proxy from the environment.
+	if *proxy != "" {
+		os.Setenv("HTTPS_PROXY", *proxy)
+		os.Setenv("HTTP_PROXY", *proxy)
+	}
+
+	if *auditLog != "" {
+		if err := openAuditLog(*auditLog); err != nil {
+			fatal(err)
+		}
+	}
 
-		// Separate the topicID from the subscription IDs.
-		topics := make(Topics)
-		for _, part := range parts[1:] {
-			topicParts := strings.Split(part, ":")
-			topics[topicParts[0]] = topicParts[1:]
+	if *discover {
+		if err := useDiscoveredEmulator(context.Background()); err != nil {
+			fatal(err)
 		}
+	}
+
+	if flag.NArg() > 0 {
+		command, ok := commands[flag.Arg(0)]
+		if !ok {
+			fatalf("Unknown command %q", flag.Arg(0))
+		}
+		if len(emulatorHosts) > 0 && !emulatorHostCommands[flag.Arg(0)] {
+			fatalf("%s: --emulator-host only applies to provisioning and the backup, destroy, dlq, explain, metrics-exporter, restore, smoke and verify commands", flag.Arg(0))
+		}
+
+		// Some commands run until interrupted, and then clean up after
+		// themselves.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := command(ctx, flag.Args()[1:])
+		stop()
+		if err != nil {
+			fatal(err)
+		}
+		return
+	}
 
-		// Create the project and all its topics and subscriptions.
-		if err := create(context.Background(), parts[0], topics); err != nil {
-			fatalf(err.Error())
+	config, err := loadConfig(*configFile, *projectsFile, nameData(*branch, *buildID, *nameUser, runID))
+	if err != nil {
+		emit(event{Type: "error", Error: err.Error(), Code: errorCode(err)})
+		emitSummary(err)
+		fatal(err)
+	}
+	emitParsed(config)
+
+	// Without any projects, print the usage info.
+	if len(config.Projects) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := config.checkLimits(*maxTopics, *maxSubscriptions); err != nil {
+		emit(event{Type: "error", Error: err.Error(), Code: errorCode(err)})
+		emitSummary(err)
+		fatal(err)
+	}
+
+	// Skip the whole run if the config was already applied and nothing it
+	// declares has gone since.
+	var hash string
+	if *cacheFile != "" {
+		if hash, err = configHash(config); err != nil {
+			emitSummary(err)
+			fatal(err)
+		}
+		if !*force {
+			ok, err := upToDate(context.Background(), config, *cacheFile, hash)
+			if err != nil {
+				emitSummary(err)
+				fatal(err)
+			}
+			if ok {
+				fmt.Fprintln(logOutput, "Config unchanged since it was last applied, skipping")
+				emitSummary(nil)
+				return
+			}
+		}
+	}
+
+	if *failIfExists {
+		if err := checkPristine(context.Background(), config); err != nil {
+			emitSummary(err)
+			fatal(err)
+		}
+	}
+
+	// Create the projects and all their topics and subscriptions.
+	if err := provision(context.Background(), config); err != nil {
+		emitSummary(err)
+		fatal(err)
+	}
+	if *cacheFile != "" {
+		if err := writeCache(*cacheFile, hash); err != nil {
+			emitSummary(err)
+			fatal(err)
 		}
 	}
+	emitSummary(nil)
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	pubsubapi "cloud.google.com/go/pubsub/apiv1"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+)
+
+// backlogSample is the backlog of a subscription at one point in time.
+type backlogSample struct {
+	// EmulatorHost is the project's own emulator host, if it has one.
+	EmulatorHost string
+	Project      string
+	Subscription string
+	// Undelivered counts the messages no subscriber holds a lease on, up to
+	// the sample limit.
+	Undelivered int
+	// OldestAge is the age of the oldest of those messages.
+	OldestAge time.Duration
+	Err       error
+}
+
+// metricsCommand periodically samples the backlog of every declared pull
+// subscription and serves it as Prometheus gauges.
+func metricsCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("metrics-exporter", flag.ExitOnError)
+	port := flags.Int("port", 9102, "Port to serve the metrics on, at /metrics")
+	interval := flags.Duration("interval", 15*time.Second, "How often to sample the backlog of every subscription")
+	limit := flags.Int("sample-limit", 10000, "Most messages to count in a subscription's backlog")
+	flags.Parse(args)
+
+	if *interval <= 0 || *limit <= 0 {
+		return fmt.Errorf("metrics-exporter: --interval and --sample-limit must be positive")
+	}
+
+	config, err := loadConfig(*configFile, *projectsFile, nameData(*branch, *buildID, *nameUser, runID))
+	if err != nil {
+		return err
+	}
+	if len(config.Projects) == 0 {
+		return fmt.Errorf("metrics-exporter: Expected PUBSUB_PROJECT variables or a config file declaring the subscriptions to watch")
+	}
+
+	clients := make(map[string]*pubsubapi.SubscriberClient)
+	for _, project := range config.Projects {
+		opts, err := clientOptions(ctx, project.EmulatorHost)
+		if err != nil {
+			return apiErrorf(err, "Unable to create client to project %q", project.ID)
+		}
+		client, err := pubsubapi.NewSubscriberClient(ctx, opts...)
+		if err != nil {
+			return apiErrorf(err, "Unable to create client to project %q", project.ID)
+		}
+		defer client.Close()
+		clients[project.EmulatorHost+" "+project.ID] = client
+	}
+
+	var mu sync.Mutex
+	var samples []backlogSample
+	sampleAll := func() {
+		var next []backlogSample
+		for _, project := range config.Projects {
+			_, subscriptionIDs := declaredResources(project)
+			for _, subscriptionID := range subscriptionIDs {
+				s, ok := sampleBacklog(ctx, clients[project.EmulatorHost+" "+project.ID], project.ID, subscriptionID, *limit)
+				if ok {
+					s.EmulatorHost = project.EmulatorHost
+					next = append(next, s)
+				}
+			}
+		}
+		mu.Lock()
+		samples = next
+		mu.Unlock()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current := samples
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeBacklogMetrics(w, current)
+	})
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
+	if err != nil {
+		return fmt.Errorf("Unable to listen on port %d: %s", *port, err)
+	}
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Fprintf(logOutput, "Serving backlog metrics on http://localhost:%d/metrics\n", *port)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		sampleAll()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// sampleBacklog counts the messages in the backlog of a pull subscription by
+// pulling them and then releasing them for redelivery straight away. Push
+// subscriptions can't be pulled from, pulling from a subscription with a dead
+// letter policy would count towards its delivery attempts, and holding
+// messages of an ordered subscription would hold up the rest of their ordering
+// keys, so those are skipped, returning false.
+func sampleBacklog(ctx context.Context, client *pubsubapi.SubscriberClient, projectID, subscriptionID string, limit int) (backlogSample, bool) {
+	name := subscriptionName(projectID, subscriptionID)
+	s := backlogSample{Project: projectID, Subscription: subscriptionID}
+
+	config, err := client.GetSubscription(ctx, &pubsubpb.GetSubscriptionRequest{Subscription: name})
+	if err != nil {
+		s.Err = apiErrorf(err, "Unable to get subscription %q for project %q", subscriptionID, projectID)
+		debugf("  %s", s.Err)
+		return s, true
+	}
+	if config.GetPushConfig().GetPushEndpoint() != "" || config.GetDeadLetterPolicy() != nil || config.GetEnableMessageOrdering() {
+		debugf("  Not sampling push, dead lettering or ordered subscription %q", name)
+		return s, false
+	}
+
+	var oldest time.Time
+	ackIDs, err := pullBacklog(ctx, client, name, limit, sampleLease, func(m *pubsubpb.ReceivedMessage) {
+		s.Undelivered++
+		if published := m.GetMessage().GetPublishTime().AsTime(); oldest.IsZero() || published.Before(oldest) {
+			oldest = published
+		}
+	})
+	if err != nil {
+		s.Err = apiErrorf(err, "Unable to pull from subscription %q for project %q", subscriptionID, projectID)
+		debugf("  %s", s.Err)
+	}
+	if err := releaseMessages(ctx, client, name, ackIDs); err != nil && s.Err == nil {
+		s.Err = apiErrorf(err, "Unable to release messages of subscription %q for project %q", subscriptionID, projectID)
+		debugf("  %s", s.Err)
+	}
+
+	if !oldest.IsZero() {
+		s.OldestAge = time.Since(oldest)
+	}
+	return s, true
+}
+
+// writeBacklogMetrics writes the samples in the Prometheus text format.
+func writeBacklogMetrics(w io.Writer, samples []backlogSample) {
+	labels := func(s backlogSample) string {
+		if s.EmulatorHost != "" {
+			return fmt.Sprintf("{emulator_host=%s,project=%s,subscription=%s}", strconv.Quote(s.EmulatorHost), strconv.Quote(s.Project), strconv.Quote(s.Subscription))
+		}
+		return fmt.Sprintf("{project=%s,subscription=%s}", strconv.Quote(s.Project), strconv.Quote(s.Subscription))
+	}
+	gauge := func(name, help string, value func(s backlogSample) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, s := range samples {
+			if v := value(s); v != "" {
+				fmt.Fprintf(w, "%s%s %s\n", name, labels(s), v)
+			}
+		}
+	}
+
+	gauge("pubsubc_subscription_sample_success", "Whether the last backlog sample of the subscription succeeded.", func(s backlogSample) string {
+		if s.Err != nil {
+			return "0"
+		}
+		return "1"
+	})
+	gauge("pubsubc_subscription_num_undelivered_messages", "Messages in the subscription that no subscriber holds, up to the sample limit.", func(s backlogSample) string {
+		if s.Err != nil {
+			return ""
+		}
+		return strconv.Itoa(s.Undelivered)
+	})
+	gauge("pubsubc_subscription_oldest_unacked_message_age_seconds", "Age of the oldest message in the subscription that no subscriber holds.", func(s backlogSample) string {
+		if s.Err != nil {
+			return ""
+		}
+		return strconv.FormatFloat(s.OldestAge.Seconds(), 'f', 3, 64)
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// mirrorOriginAttribute records the topic a mirrored message was first
+// published to, so that mirrors in both directions don't loop.
+const mirrorOriginAttribute = "pubsubc-mirror-origin"
+
+// mirrorCommand copies every message published to one topic to another topic,
+// usually in another project, until interrupted.
+func mirrorCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("mirror", flag.ExitOnError)
+	from := flags.String("from", "", "Full name of the topic to mirror, projects/<project>/topics/<topic>")
+	to := flags.String("to", "", "Full name of the topic to publish the messages to")
+	subscriptionID := flags.String("subscription", "", "Existing subscription on the source topic to read from, instead of a temporary one")
+	publishSettings := addPublishFlags(flags)
+	receiveSettings := addReceiveFlags(flags)
+	flags.Parse(args)
+
+	fromProjectID, fromTopicID, ok := splitTopicName(*from)
+	if !ok {
+		return fmt.Errorf("mirror: --from must be a topic name such as projects/project/topics/topic, not %q", *from)
+	}
+	toProjectID, toTopicID, ok := splitTopicName(*to)
+	if !ok {
+		return fmt.Errorf("mirror: --to must be a topic name such as projects/project/topics/topic, not %q", *to)
+	}
+
+	src, err := newClient(ctx, fromProjectID, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", fromProjectID)
+	}
+	defer src.Close()
+	dst, err := newClient(ctx, toProjectID, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", toProjectID)
+	}
+	defer dst.Close()
+
+	// Without a subscription to read from, use one that only lasts as long as
+	// the mirror.
+	subscription := src.Subscription(*subscriptionID)
+	if *subscriptionID == "" {
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return fmt.Errorf("Unable to generate subscription ID: %s", err)
+		}
+		id := fmt.Sprintf("pubsubc-mirror-%s", hex.EncodeToString(suffix))
+
+		// The subscription has the run ID label, so that destroy can delete
+		// it if the mirror is killed before it can.
+		debugf("Creating subscription %q on topic %q", id, *from)
+		done := track("create", subscriptionName(fromProjectID, id))
+		subscription, err = src.CreateSubscription(ctx, id, pubsub.SubscriptionConfig{
+			Topic:                 src.Topic(fromTopicID),
+			EnableMessageOrdering: true,
+			Labels:                runLabels(nil),
+		})
+		done(err)
+		if err != nil {
+			return apiErrorf(err, "Unable to create subscription %q on topic %q for project %q", id, fromTopicID, fromProjectID)
+		}
+		defer func() {
+			debugf("Deleting subscription %q", id)
+			done := track("delete", subscriptionName(fromProjectID, id))
+			err := subscription.Delete(context.Background())
+			done(err)
+			if err != nil {
+				debugf("  Unable to delete subscription %q: %s", id, err)
+			}
+		}()
+	}
+
+	receiveSettings.apply(subscription)
+
+	topic := dst.Topic(toTopicID)
+	topic.EnableMessageOrdering = true
+	publishSettings.apply(topic)
+	defer topic.Stop()
+
+	debugf("Mirroring %q to %q", *from, *to)
+	err = subscription.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		attributes := make(map[string]string, len(m.Attributes)+1)
+		for k, v := range m.Attributes {
+			attributes[k] = v
+		}
+		if origin, ok := attributes[mirrorOriginAttribute]; ok && origin == *to {
+			m.Ack()
+			return
+		}
+		if _, ok := attributes[mirrorOriginAttribute]; !ok {
+			attributes[mirrorOriginAttribute] = *from
+		}
+
+		result := topic.Publish(ctx, &pubsub.Message{
+			Data:        m.Data,
+			Attributes:  attributes,
+			OrderingKey: m.OrderingKey,
+		})
+		if _, err := result.Get(ctx); err != nil {
+			debugf("  Unable to mirror message %q: %s", m.ID, err)
+			if m.OrderingKey != "" {
+				topic.ResumePublish(m.OrderingKey)
+			}
+			m.Nack()
+			return
+		}
+		debugf("  Mirrored message %q", m.ID)
+		m.Ack()
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return apiErrorf(err, "Unable to receive from topic %q", *from)
+	}
+
+	return nil
+}
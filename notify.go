@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// notifyTimeout bounds the completion notification, so that a slow webhook
+// doesn't hold up the end of the run.
+const notifyTimeout = 10 * time.Second
+
+// slackMessage is the payload of a Slack incoming webhook.
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// notify POSTs the summary of the run to --notify-url, as the summary event or
+// with --notify-format slack as a Slack message. Failing to notify is reported
+// but doesn't fail the run.
+func notify(summary event) {
+	if *notifyURL == "" {
+		return
+	}
+
+	summary.Time = time.Now().UTC()
+	summary.RunID = runID
+	var payload interface{} = summary
+	if *notifyFormat == "slack" {
+		outcome := "succeeded"
+		if summary.Success != nil && !*summary.Success {
+			outcome = "failed"
+		}
+		text := fmt.Sprintf("pubsubc run %s %s in %s: %d created, %d updated, %d skipped, %d deleted, %d failed", runID, outcome,
+			(time.Duration(summary.DurationMS) * time.Millisecond).String(), summary.Counts["created"], summary.Counts["updated"],
+			summary.Counts["skipped"], summary.Counts["deleted"], summary.Counts["failed"])
+		if summary.Error != "" {
+			text += "\n" + summary.Error
+		}
+		payload = slackMessage{Text: text}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *notifyURL, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		var resp *http.Response
+		if resp, err = http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("webhook responded %s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: Unable to notify %q: %s\n", os.Args[0], *notifyURL, err)
+	}
+}
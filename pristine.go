@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// declaredResources returns the IDs of the topics and subscriptions that
+// creating the project makes, including dead letter ones.
+func declaredResources(project *Project) (topicIDs, subscriptionIDs []string) {
+	for _, topic := range project.Topics {
+		topicIDs = append(topicIDs, topic.ID)
+		deadLetterTopic := false
+		for _, subscription := range topic.Subscriptions {
+			subscriptionIDs = append(subscriptionIDs, subscription.ID)
+			if subscription.ownDeadLetters() {
+				subscriptionIDs = append(subscriptionIDs, subscription.deadLetterSubscriptionID())
+				deadLetterTopic = true
+			}
+		}
+		if deadLetterTopic {
+			topicIDs = append(topicIDs, topic.deadLetterTopicID())
+		}
+	}
+	return topicIDs, subscriptionIDs
+}
+
+// existingResources returns the full names of the declared topics and
+// subscriptions of the project that already exist.
+func existingResources(ctx context.Context, project *Project) ([]string, error) {
+	client, err := newClient(ctx, project.ID, project.EmulatorHost)
+	if err != nil {
+		return nil, apiErrorf(err, "Unable to create client to project %q", project.ID)
+	}
+	defer client.Close()
+
+	var existing []string
+	topicIDs, subscriptionIDs := declaredResources(project)
+	for _, topicID := range topicIDs {
+		ok, err := client.Topic(topicID).Exists(ctx)
+		if err != nil {
+			return nil, apiErrorf(err, "Unable to check topic %q for project %q", topicID, project.ID)
+		}
+		if ok {
+			existing = append(existing, topicName(project.ID, topicID))
+		}
+	}
+	for _, subscriptionID := range subscriptionIDs {
+		ok, err := client.Subscription(subscriptionID).Exists(ctx)
+		if err != nil {
+			return nil, apiErrorf(err, "Unable to check subscription %q for project %q", subscriptionID, project.ID)
+		}
+		if ok {
+			existing = append(existing, subscriptionName(project.ID, subscriptionID))
+		}
+	}
+
+	return existing, nil
+}
+
+// checkPristine returns an error listing every declared resource that already
+// exists, so that a run can be guaranteed to start from an empty emulator.
+func checkPristine(ctx context.Context, config *Config) error {
+	var existing []string
+	for _, project := range config.Projects {
+		debugf("Checking project %q for existing resources", project.ID)
+		resources, err := existingResources(ctx, project)
+		if err != nil {
+			return err
+		}
+		existing = append(existing, resources...)
+	}
+
+	if len(existing) == 0 {
+		return nil
+	}
+	return ErrAlreadyExists.wrapf("Resources already exist:\n  %s", strings.Join(existing, "\n  "))
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// protoType is a message or enum of a FileDescriptorSet, with the package and
+// syntax of the file declaring it.
+type protoType struct {
+	pkg     string
+	syntax  string
+	message *descriptorpb.DescriptorProto
+	enum    *descriptorpb.EnumDescriptorProto
+}
+
+// protoTypes indexes the messages and enums of a FileDescriptorSet by full
+// name, with a leading dot as in the type names of fields.
+func protoTypes(set *descriptorpb.FileDescriptorSet) map[string]protoType {
+	types := make(map[string]protoType)
+	for _, file := range set.GetFile() {
+		pkg, syntax := file.GetPackage(), file.GetSyntax()
+		if syntax == "" {
+			syntax = "proto2"
+		}
+		var add func(prefix string, messages []*descriptorpb.DescriptorProto, enums []*descriptorpb.EnumDescriptorProto)
+		add = func(prefix string, messages []*descriptorpb.DescriptorProto, enums []*descriptorpb.EnumDescriptorProto) {
+			for _, message := range messages {
+				name := prefix + "." + message.GetName()
+				types[name] = protoType{pkg: pkg, syntax: syntax, message: message}
+				add(name, message.GetNestedType(), message.GetEnumType())
+			}
+			for _, enum := range enums {
+				types[prefix+"."+enum.GetName()] = protoType{pkg: pkg, syntax: syntax, enum: enum}
+			}
+		}
+		prefix := ""
+		if pkg != "" {
+			prefix = "." + pkg
+		}
+		add(prefix, file.GetMessageType(), file.GetEnumType())
+	}
+	return types
+}
+
+// protoSchema renders the message with the full name as the definition of a
+// protobuf schema, which Pub/Sub requires to be a single message without
+// imports. The messages and enums it uses from outside it are nested in it,
+// in a message for each of their packages, along with the rest of the top
+// level message or enum they belong to, so that their fields and enum values
+// keep their names and numbers.
+func protoSchema(types map[string]protoType, name string) (string, error) {
+	top := types[name]
+	if top.syntax != "proto2" && top.syntax != "proto3" {
+		return "", fmt.Errorf("%s is declared with syntax %q: expected proto2 or proto3", strings.TrimPrefix(name, "."), top.syntax)
+	}
+	r := &schemaRenderer{types: types, top: name, renamed: "." + top.message.GetName()}
+	if top.pkg != "" {
+		r.renamed = "." + top.pkg + r.renamed
+	}
+	r.nested = make(map[string]bool)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "syntax = %q;\n", top.syntax)
+	if top.pkg != "" {
+		fmt.Fprintf(&b, "package %s;\n", top.pkg)
+	}
+	if err := r.message(&b, top.message, name, ""); err != nil {
+		return "", err
+	}
+
+	// Nesting a type can bring in others, so the nested types are rendered
+	// until there are no more.
+	var packages []string
+	nested := make(map[string][]string)
+	for i := 0; i < len(r.queue); i++ {
+		root := r.queue[i]
+		t := types[root]
+		// proto3 messages keep their encoding in a proto2 schema, but not the
+		// other way around.
+		if t.syntax != top.syntax && t.syntax != "proto3" {
+			return "", fmt.Errorf("%s uses %s, which is declared in a %s file", strings.TrimPrefix(name, "."), strings.TrimPrefix(root, "."), t.syntax)
+		}
+		var rendered strings.Builder
+		var err error
+		if t.message != nil {
+			err = r.message(&rendered, t.message, root, "    ")
+		} else {
+			r.enum(&rendered, t.enum, "    ")
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, ok := nested[t.pkg]; !ok {
+			packages = append(packages, t.pkg)
+		}
+		nested[t.pkg] = append(nested[t.pkg], rendered.String())
+	}
+
+	definition := strings.TrimSuffix(b.String(), "}\n")
+	if len(packages) == 0 {
+		return definition + "}\n", nil
+	}
+	b.Reset()
+	b.WriteString(definition)
+	for _, pkg := range packages {
+		fmt.Fprintf(&b, "  message %s {\n%s  }\n", packageMessage(pkg), strings.Join(nested[pkg], ""))
+	}
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+// packageMessage returns the name of the message nesting the types of the
+// package in a schema.
+func packageMessage(pkg string) string {
+	if pkg == "" {
+		return "no_package"
+	}
+	return strings.ReplaceAll(pkg, ".", "_")
+}
+
+// schemaRenderer renders the messages and enums of a protobuf schema.
+type schemaRenderer struct {
+	types map[string]protoType
+	// top is the full name of the schema's message in the descriptor set, and
+	// renamed its full name in the schema.
+	top, renamed string
+	// queue has the top level types to nest in the schema's message, in the
+	// order they were first used, and nested all of them.
+	queue  []string
+	nested map[string]bool
+}
+
+// typeName returns the name of the type with the full name in the schema,
+// queueing it to be nested if it is outside the schema's message.
+func (r *schemaRenderer) typeName(name string) (string, error) {
+	if name == r.top || strings.HasPrefix(name, r.top+".") {
+		return r.renamed + strings.TrimPrefix(name, r.top), nil
+	}
+	t, ok := r.types[name]
+	if !ok {
+		return "", fmt.Errorf("type %s isn't in the descriptor set; write it with protoc --include_imports", strings.TrimPrefix(name, "."))
+	}
+
+	prefix := ""
+	if t.pkg != "" {
+		prefix = "." + t.pkg
+	}
+	relative := strings.TrimPrefix(name, prefix)
+	root := prefix + "." + strings.Split(relative, ".")[1]
+	if !r.nested[root] {
+		r.nested[root] = true
+		r.queue = append(r.queue, root)
+	}
+	return r.renamed + "." + packageMessage(t.pkg) + relative, nil
+}
+
+// message renders the message with the full name and its nested types, except
+// the schema's message if it is one of them.
+func (r *schemaRenderer) message(b *strings.Builder, message *descriptorpb.DescriptorProto, name, indent string) error {
+	fmt.Fprintf(b, "%smessage %s {\n", indent, message.GetName())
+
+	mapEntries := make(map[string]*descriptorpb.DescriptorProto)
+	for _, nested := range message.GetNestedType() {
+		if nested.GetOptions().GetMapEntry() {
+			mapEntries[name+"."+nested.GetName()] = nested
+			continue
+		}
+		if name+"."+nested.GetName() == r.top && name != r.top {
+			continue
+		}
+		if err := r.message(b, nested, name+"."+nested.GetName(), indent+"  "); err != nil {
+			return err
+		}
+	}
+	for _, enum := range message.GetEnumType() {
+		r.enum(b, enum, indent+"  ")
+	}
+
+	oneofs := message.GetOneofDecl()
+	rendered := make(map[int32]bool)
+	for _, field := range message.GetField() {
+		if field.OneofIndex == nil || field.GetProto3Optional() {
+			line, err := r.field(field, mapEntries, true)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(b, "%s  %s\n", indent, line)
+			continue
+		}
+		i := field.GetOneofIndex()
+		if rendered[i] {
+			continue
+		}
+		rendered[i] = true
+		fmt.Fprintf(b, "%s  oneof %s {\n", indent, oneofs[i].GetName())
+		for _, member := range message.GetField() {
+			if member.OneofIndex == nil || member.GetOneofIndex() != i || member.GetProto3Optional() {
+				continue
+			}
+			line, err := r.field(member, mapEntries, false)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(b, "%s    %s\n", indent, line)
+		}
+		fmt.Fprintf(b, "%s  }\n", indent)
+	}
+
+	fmt.Fprintf(b, "%s}\n", indent)
+	return nil
+}
+
+// field renders a field declaration, with its label unless it is in a oneof.
+func (r *schemaRenderer) field(field *descriptorpb.FieldDescriptorProto, mapEntries map[string]*descriptorpb.DescriptorProto, label bool) (string, error) {
+	var fieldType string
+	if entry, ok := mapEntries[field.GetTypeName()]; ok {
+		key, err := r.fieldType(entry.GetField()[0])
+		if err != nil {
+			return "", err
+		}
+		value, err := r.fieldType(entry.GetField()[1])
+		if err != nil {
+			return "", err
+		}
+		fieldType, label = fmt.Sprintf("map<%s, %s>", key, value), false
+	} else {
+		var err error
+		if fieldType, err = r.fieldType(field); err != nil {
+			return "", err
+		}
+	}
+
+	var prefix string
+	if label {
+		switch {
+		case field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED:
+			prefix = "repeated "
+		case field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REQUIRED:
+			prefix = "required "
+		case field.GetProto3Optional() || r.types[r.top].syntax == "proto2":
+			prefix = "optional "
+		}
+	}
+
+	// Only the options that change how messages are encoded are kept.
+	var options []string
+	if field.GetOptions() != nil && field.GetOptions().Packed != nil {
+		options = append(options, fmt.Sprintf("packed = %t", field.GetOptions().GetPacked()))
+	}
+	if field.JsonName != nil && field.GetJsonName() != jsonName(field.GetName()) {
+		options = append(options, fmt.Sprintf("json_name = %q", field.GetJsonName()))
+	}
+	line := fmt.Sprintf("%s%s %s = %d", prefix, fieldType, field.GetName(), field.GetNumber())
+	if len(options) > 0 {
+		line += " [" + strings.Join(options, ", ") + "]"
+	}
+	return line + ";", nil
+}
+
+// fieldType returns the type of a field as declared in the schema.
+func (r *schemaRenderer) fieldType(field *descriptorpb.FieldDescriptorProto) (string, error) {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return r.typeName(field.GetTypeName())
+	case descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return "", fmt.Errorf("field %s is a group, which schemas can't have", field.GetName())
+	}
+	return strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_")), nil
+}
+
+// enum renders an enum declaration.
+func (r *schemaRenderer) enum(b *strings.Builder, enum *descriptorpb.EnumDescriptorProto, indent string) {
+	fmt.Fprintf(b, "%senum %s {\n", indent, enum.GetName())
+	if enum.GetOptions().GetAllowAlias() {
+		fmt.Fprintf(b, "%s  option allow_alias = true;\n", indent)
+	}
+	for _, value := range enum.GetValue() {
+		fmt.Fprintf(b, "%s  %s = %d;\n", indent, value.GetName(), value.GetNumber())
+	}
+	fmt.Fprintf(b, "%s}\n", indent)
+}
+
+// jsonName returns the JSON name protoc gives a field by default, its name in
+// lower camel case.
+func jsonName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, c := range name {
+		switch {
+		case c == '_':
+			upper = true
+		case upper && 'a' <= c && c <= 'z':
+			b.WriteRune(c - 'a' + 'A')
+			upper = false
+		default:
+			b.WriteRune(c)
+			upper = false
+		}
+	}
+	return b.String()
+}
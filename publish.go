@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// contentEncodingAttribute names the compression of a message's data, as
+// consumers that decompress expect.
+const contentEncodingAttribute = "content-encoding"
+
+// maxMessageLine is the longest line of a publish input, allowing for the
+// 10MB limit on message data once it is quoted.
+const maxMessageLine = 32 << 20
+
+// inputMessage is a line of the publish input.
+type inputMessage struct {
+	// Data is published as is if it is a string, or else as its JSON.
+	Data        json.RawMessage   `json:"data"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	OrderingKey string            `json:"ordering_key,omitempty"`
+}
+
+// data returns the data to publish for the message.
+func (m *inputMessage) data() ([]byte, error) {
+	if len(m.Data) == 0 {
+		return nil, nil
+	}
+	var s string
+	if err := json.Unmarshal(m.Data, &s); err == nil {
+		return []byte(s), nil
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, m.Data); err != nil {
+		return nil, err
+	}
+	return compact.Bytes(), nil
+}
+
+// compressData compresses data in the format, which is one of those --compress
+// accepts.
+func compressData(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "":
+		return data, nil
+	case "gzip":
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("Unknown compression %q: expected gzip", format)
+	}
+}
+
+// publishCommand publishes messages read as JSON lines to a topic, for seeding
+// fixtures.
+func publishCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	projectID := flags.String("project", defaultProjectID(), "Project ID containing the topic")
+	topicID := flags.String("topic", "", "Topic to publish to")
+	file := flags.String("file", "", "File of messages to publish, one JSON object per line, instead of stdin")
+	compress := flags.String("compress", "", "Compress message data in this format and set the content-encoding attribute: gzip")
+	protoFile := flags.String("proto-file", "", "Encode the JSON data of every message as a binary protobuf message defined in this .proto file")
+	messageType := flags.String("message-type", "", "Full name of the protobuf message type to encode the data as, with --proto-file")
+	avroSchema := flags.String("avro-schema", "", "Encode the JSON data of every message as an Avro record of the schema in this .avsc file")
+	checkpointFile := flags.String("checkpoint", "", "Record progress through the messages in this file, defaulting to the messages file with a .checkpoint suffix")
+	resume := flags.Bool("resume", false, "Skip the messages the checkpoint records as published by an earlier, interrupted run")
+	verify := flags.Bool("verify-delivery", false, "Check that every pull subscription of the topic receives the published messages")
+	verifyTimeout := flags.Duration("verify-timeout", 30*time.Second, "How long each subscription has to receive the messages, with --verify-delivery")
+	avroEncoding := flags.String("avro-encoding", "", "Avro encoding to publish, binary or json, defaulting to that of the topic's schema settings or else binary")
+	publishSettings := addPublishFlags(flags)
+	flags.Parse(args)
+
+	if *projectID == "" || *topicID == "" {
+		return fmt.Errorf("publish: --project and --topic are required")
+	}
+	if _, err := compressData(nil, *compress); err != nil {
+		return fmt.Errorf("publish: %s", err)
+	}
+	if (*protoFile == "") != (*messageType == "") {
+		return fmt.Errorf("publish: --proto-file and --message-type must be given together")
+	}
+	if *protoFile != "" && *avroSchema != "" {
+		return fmt.Errorf("publish: only one of --proto-file and --avro-schema can be given")
+	}
+	if *avroEncoding != "" && *avroEncoding != "binary" && *avroEncoding != "json" {
+		return fmt.Errorf("publish: Invalid --avro-encoding %q: expected binary or json", *avroEncoding)
+	}
+
+	encode := (*inputMessage).data
+	if *protoFile != "" {
+		protoEncode, err := protoEncoder(ctx, *protoFile, *messageType)
+		if err != nil {
+			return err
+		}
+		encode = func(m *inputMessage) ([]byte, error) {
+			return protoEncode(m.Data)
+		}
+	}
+
+	if *checkpointFile == "" && *file != "" {
+		*checkpointFile = *file + ".checkpoint"
+	}
+	if *resume && *checkpointFile == "" {
+		return fmt.Errorf("publish: --resume needs --checkpoint when reading from stdin")
+	}
+	var progress *checkpoint
+	if *checkpointFile != "" {
+		var err error
+		if progress, err = loadCheckpoint(*checkpointFile, *resume); err != nil {
+			return err
+		}
+		if progress.start > 0 {
+			debugf("Resuming after line %d", progress.start)
+		}
+	}
+
+	var input io.Reader = os.Stdin
+	name := "stdin"
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("Unable to open messages file %q: %s", *file, err)
+		}
+		defer f.Close()
+		input, name = f, *file
+	}
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", *projectID)
+	}
+	defer client.Close()
+
+	topic := client.Topic(*topicID)
+	if *avroSchema != "" {
+		textual := *avroEncoding == "json"
+		if *avroEncoding == "" {
+			config, err := topic.Config(ctx)
+			if err != nil {
+				return apiErrorf(err, "Unable to get topic %q for project %q", *topicID, *projectID)
+			}
+			textual = config.SchemaSettings != nil && config.SchemaSettings.Encoding == pubsub.EncodingJSON
+		}
+		avroEncode, err := avroEncoder(*avroSchema, textual)
+		if err != nil {
+			return err
+		}
+		encode = func(m *inputMessage) ([]byte, error) {
+			return avroEncode(m.Data)
+		}
+	}
+	topic.EnableMessageOrdering = true
+	publishSettings.apply(topic)
+	defer topic.Stop()
+
+	var wg sync.WaitGroup
+	var published, failed int64
+	var mu sync.Mutex
+	var publishErr error
+	ids := make(map[string]bool)
+
+	debugf("Publishing messages from %s to topic %q on project %q", name, *topicID, *projectID)
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, maxMessageLine)
+	if progress != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go progress.writeEvery(time.Second, stop)
+	}
+	for line := 1; scanner.Scan() && ctx.Err() == nil; line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 || (progress != nil && progress.skip(line)) {
+			continue
+		}
+		var m inputMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return fmt.Errorf("Unable to parse message on line %d of %s: %s", line, name, err)
+		}
+		data, err := encode(&m)
+		if err != nil {
+			return fmt.Errorf("Unable to encode message on line %d of %s: %s", line, name, err)
+		}
+		if data, err = compressData(data, *compress); err != nil {
+			return fmt.Errorf("Unable to compress message on line %d of %s: %s", line, name, err)
+		}
+		if *compress != "" {
+			if m.Attributes == nil {
+				m.Attributes = make(map[string]string)
+			}
+			m.Attributes[contentEncodingAttribute] = *compress
+		}
+
+		if progress != nil {
+			progress.publishing(line)
+		}
+		result := topic.Publish(ctx, &pubsub.Message{
+			Data:        data,
+			Attributes:  m.Attributes,
+			OrderingKey: m.OrderingKey,
+		})
+		wg.Add(1)
+		go func(line int) {
+			defer wg.Done()
+			id, err := result.Get(ctx)
+			if err != nil {
+				atomic.AddInt64(&failed, 1)
+				debugf("  Unable to publish message on line %d: %s", line, err)
+				mu.Lock()
+				if publishErr == nil {
+					publishErr = apiErrorf(err, "Unable to publish message on line %d of %s to topic %q", line, name, *topicID)
+				}
+				mu.Unlock()
+				return
+			}
+			atomic.AddInt64(&published, 1)
+			if progress != nil {
+				progress.published(line)
+			}
+			if *verify {
+				mu.Lock()
+				ids[id] = true
+				mu.Unlock()
+			}
+		}(line)
+	}
+	wg.Wait()
+	if progress != nil {
+		if err := progress.write(); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Unable to read messages from %s: %s", name, err)
+	}
+
+	fmt.Fprintf(logOutput, "Published %d messages to topic %q (%d failed)\n", published, *topicID, failed)
+	if publishErr != nil || !*verify {
+		return publishErr
+	}
+
+	deliveries, err := verifyDelivery(ctx, client, topic, ids, *verifyTimeout)
+	for _, d := range deliveries {
+		if d.Skipped != "" {
+			fmt.Fprintf(logOutput, "Skipped %s: %s\n", d.Subscription, d.Skipped)
+		} else {
+			fmt.Fprintf(logOutput, "Delivered %d of %d messages to %s in %s\n", d.Received, d.Expected, d.Subscription, d.Elapsed.Round(time.Millisecond))
+		}
+	}
+	return err
+}
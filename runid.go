@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"regexp"
+
+	"google.golang.org/api/iterator"
+)
+
+// runIDLabel is the label every created topic and subscription gets, holding
+// the ID of the run that created it.
+const runIDLabel = "pubsubc-run-id"
+
+// runIDPattern matches run IDs that are valid label values.
+var runIDPattern = regexp.MustCompile(`^[a-z0-9_-]{1,63}$`)
+
+// runID identifies this invocation in logs, events, the audit log, the cache
+// file and resource labels. It is set from --run-id or generated.
+var runID string
+
+// setRunID sets the run ID to id, or generates one if it is empty.
+func setRunID(id string) error {
+	if id == "" {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("Unable to generate run ID: %s", err)
+		}
+		id = hex.EncodeToString(b)
+	}
+	if !runIDPattern.MatchString(id) {
+		return fmt.Errorf("Invalid --run-id %q: expected up to 63 lowercase letters, numbers, dashes and underscores", id)
+	}
+	runID = id
+	return nil
+}
+
+// runLabels returns labels with the run ID label added.
+func runLabels(labels map[string]string) map[string]string {
+	withRunID := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		withRunID[k] = v
+	}
+	withRunID[runIDLabel] = runID
+	return withRunID
+}
+
+// destroyCommand deletes the topics and subscriptions labelled with a run ID,
+// so that parallel CI runs sharing an emulator or project each clean up only
+// their own resources.
+func destroyCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("destroy", flag.ExitOnError)
+	id := flags.String("run-id", "", "Run ID of the resources to delete")
+	projectID := flags.String("project", "", "Project to delete from, instead of those declared in the config")
+	flags.Parse(args)
+
+	if *id == "" {
+		return fmt.Errorf("destroy: --run-id is required")
+	}
+
+	// With --tags only the resources of the selected config are deleted, so
+	// the config is needed even with --project.
+	projects := []*Project{{ID: *projectID}}
+	if len(emulatorHosts) > 0 {
+		projects = nil
+		for _, host := range emulatorHosts {
+			projects = append(projects, &Project{ID: *projectID, EmulatorHost: host})
+		}
+	}
+	var declared map[string]bool
+	if *projectID == "" || len(selectedTags) > 0 {
+		config, err := loadConfig(*configFile, *projectsFile, nameData(*branch, *buildID, *nameUser, *id))
+		if err != nil {
+			return err
+		}
+		projects = nil
+		for _, project := range config.Projects {
+			if *projectID == "" || project.ID == *projectID {
+				projects = append(projects, project)
+			}
+		}
+		if len(selectedTags) > 0 {
+			declared = make(map[string]bool)
+			for _, project := range projects {
+				topicIDs, subscriptionIDs := declaredResources(project)
+				for _, topicID := range topicIDs {
+					declared[project.EmulatorHost+" "+topicName(project.ID, topicID)] = true
+				}
+				for _, subscriptionID := range subscriptionIDs {
+					declared[project.EmulatorHost+" "+subscriptionName(project.ID, subscriptionID)] = true
+				}
+			}
+		}
+	}
+
+	for _, project := range projects {
+		if err := destroyRun(ctx, project, *id, declared); err != nil {
+			emitSummary(err)
+			return err
+		}
+	}
+	emitSummary(nil)
+	return nil
+}
+
+// destroyRun deletes the subscriptions and then the topics of the project that
+// are labelled with the run ID. If declared isn't nil, only those it has, by
+// emulator host and full name, are deleted.
+func destroyRun(ctx context.Context, project *Project, id string, declared map[string]bool) error {
+	client, err := newClient(ctx, project.ID, project.EmulatorHost)
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", project.ID)
+	}
+	defer client.Close()
+
+	debugf("Deleting the resources of run %q from project %q", id, project.ID)
+	subscriptions := client.Subscriptions(ctx)
+	for {
+		subscription, err := subscriptions.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return apiErrorf(err, "Unable to list subscriptions for project %q", project.ID)
+		}
+		config, err := subscription.Config(ctx)
+		if err != nil {
+			return apiErrorf(err, "Unable to get subscription %q for project %q", subscription.ID(), project.ID)
+		}
+		if config.Labels[runIDLabel] != id || (declared != nil && !declared[project.EmulatorHost+" "+subscription.String()]) {
+			continue
+		}
+
+		debugf("  Deleting subscription %q", subscription.ID())
+		done := track("delete", subscriptionName(project.ID, subscription.ID()))
+		err = subscription.Delete(ctx)
+		done(err)
+		if err != nil {
+			return apiErrorf(err, "Unable to delete subscription %q for project %q", subscription.ID(), project.ID)
+		}
+	}
+
+	topics := client.Topics(ctx)
+	for {
+		topic, err := topics.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return apiErrorf(err, "Unable to list topics for project %q", project.ID)
+		}
+		config, err := topic.Config(ctx)
+		if err != nil {
+			return apiErrorf(err, "Unable to get topic %q for project %q", topic.ID(), project.ID)
+		}
+		if config.Labels[runIDLabel] != id || (declared != nil && !declared[project.EmulatorHost+" "+topic.String()]) {
+			continue
+		}
+
+		debugf("  Deleting topic %q", topic.ID())
+		done := track("delete", topicName(project.ID, topic.ID()))
+		err = topic.Delete(ctx)
+		done(err)
+		if err != nil {
+			return apiErrorf(err, "Unable to delete topic %q for project %q", topic.ID(), project.ID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+)
+
+// schemaTypes are the schema types by the names the schema commands use.
+var schemaTypes = map[string]pubsub.SchemaType{
+	"avro":     pubsub.SchemaAvro,
+	"protobuf": pubsub.SchemaProtocolBuffer,
+}
+
+// schemaTypeName returns the name of a schema type.
+func schemaTypeName(t pubsub.SchemaType) string {
+	for name, schemaType := range schemaTypes {
+		if schemaType == t {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// schemaName returns the full resource name of a schema.
+func schemaName(projectID, schemaID string) string {
+	return fmt.Sprintf("projects/%s/schemas/%s", projectID, schemaID)
+}
+
+// splitSchemaName splits a full schema name into its project and schema IDs.
+func splitSchemaName(name string) (projectID, schemaID string, ok bool) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != "schemas" {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+// readTopicSchema reads a schema of the project, with its definition, as a
+// topic schema of the config.
+func readTopicSchema(ctx context.Context, client *pubsub.SchemaClient, projectID, schemaID string) (*TopicSchema, error) {
+	config, err := client.Schema(ctx, schemaID, pubsub.SchemaViewFull)
+	if err != nil {
+		return nil, apiErrorf(err, "Unable to get schema %q for project %q", schemaID, projectID)
+	}
+	return &TopicSchema{ID: schemaID, Type: schemaTypeName(config.Type), Definition: config.Definition}, nil
+}
+
+// newSchemaClient creates a schema client for the project, connecting the same
+// way as newClient.
+func newSchemaClient(ctx context.Context, projectID, emulatorHost string) (*pubsub.SchemaClient, error) {
+	opts, err := clientOptions(ctx, emulatorHost)
+	if err != nil {
+		return nil, err
+	}
+	return pubsub.NewSchemaClient(ctx, projectID, opts...)
+}
+
+// schemaCommand runs one of the schema subcommands.
+func schemaCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Expected a schema command: create, list, describe or delete")
+	}
+
+	switch args[0] {
+	case "create", "list", "describe", "delete":
+	default:
+		return fmt.Errorf("Unknown schema command %q", args[0])
+	}
+
+	flags := flag.NewFlagSet("schema "+args[0], flag.ExitOnError)
+	projectID := flags.String("project", defaultProjectID(), "Project ID containing the schemas")
+	var schemaID, schemaType, definition *string
+	if args[0] != "list" {
+		schemaID = flags.String("schema", "", "ID of the schema")
+	}
+	if args[0] == "create" {
+		schemaType = flags.String("type", "", "Type of the schema: avro or protobuf")
+		definition = flags.String("definition", "", "File holding the schema definition, an .avsc or .proto file")
+	}
+	flags.Parse(args[1:])
+
+	if *projectID == "" {
+		return fmt.Errorf("schema %s: --project is required", args[0])
+	}
+	if schemaID != nil && *schemaID == "" {
+		return fmt.Errorf("schema %s: --schema is required", args[0])
+	}
+
+	client, err := newSchemaClient(ctx, *projectID, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create schema client to project %q", *projectID)
+	}
+	defer client.Close()
+
+	switch args[0] {
+	case "create":
+		return createSchema(ctx, client, *projectID, *schemaID, *schemaType, *definition)
+	case "list":
+		return listSchemas(ctx, client, *projectID)
+	case "describe":
+		return describeSchema(ctx, client, *projectID, *schemaID)
+	default:
+		debugf("Deleting schema %q", *schemaID)
+		done := track("delete", schemaName(*projectID, *schemaID))
+		err := client.DeleteSchema(ctx, *schemaID)
+		done(err)
+		if err != nil {
+			return apiErrorf(err, "Unable to delete schema %q for project %q", *schemaID, *projectID)
+		}
+		return nil
+	}
+}
+
+// createSchema creates a schema from the definition in a file.
+func createSchema(ctx context.Context, client *pubsub.SchemaClient, projectID, schemaID, typeName, definitionFile string) error {
+	schemaType, ok := schemaTypes[typeName]
+	if !ok {
+		return fmt.Errorf("schema create: --type must be avro or protobuf, not %q", typeName)
+	}
+	definition, err := os.ReadFile(definitionFile)
+	if err != nil {
+		return fmt.Errorf("Unable to read schema definition %q: %s", definitionFile, err)
+	}
+
+	debugf("Creating %s schema %q", typeName, schemaID)
+	done := track("create", schemaName(projectID, schemaID))
+	_, err = client.CreateSchema(ctx, schemaID, pubsub.SchemaConfig{
+		Type:       schemaType,
+		Definition: string(definition),
+	})
+	done(err)
+	if err != nil {
+		return apiErrorf(err, "Unable to create schema %q for project %q", schemaID, projectID)
+	}
+	return nil
+}
+
+// listSchemas prints the ID and type of every schema in the project.
+func listSchemas(ctx context.Context, client *pubsub.SchemaClient, projectID string) error {
+	schemas := client.Schemas(ctx, pubsub.SchemaViewBasic)
+	for {
+		schema, err := schemas.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return apiErrorf(err, "Unable to list schemas for project %q", projectID)
+		}
+		fmt.Printf("%s\t%s\n", schema.Name[strings.LastIndex(schema.Name, "/")+1:], schemaTypeName(schema.Type))
+	}
+}
+
+// describeSchema prints a schema, including its definition, as YAML.
+func describeSchema(ctx context.Context, client *pubsub.SchemaClient, projectID, schemaID string) error {
+	schema, err := client.Schema(ctx, schemaID, pubsub.SchemaViewFull)
+	if err != nil {
+		return apiErrorf(err, "Unable to get schema %q for project %q", schemaID, projectID)
+	}
+
+	description := struct {
+		Name       string    `yaml:"name"`
+		Type       string    `yaml:"type"`
+		RevisionID string    `yaml:"revision_id,omitempty"`
+		Created    time.Time `yaml:"revision_create_time,omitempty"`
+		Definition string    `yaml:"definition"`
+	}{schema.Name, schemaTypeName(schema.Type), schema.RevisionID, schema.RevisionCreateTime, schema.Definition}
+	return writeYAML(os.Stdout, description)
+}
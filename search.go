@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	pubsubapi "cloud.google.com/go/pubsub/apiv1"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+)
+
+// attributeFilter holds the attributes given with repeated --attr flags.
+type attributeFilter map[string]string
+
+func (f attributeFilter) String() string {
+	var pairs []string
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f attributeFilter) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected key=value, not %q", s)
+	}
+	f[k] = v
+	return nil
+}
+
+// matches reports whether the attributes have every attribute of the filter.
+func (f attributeFilter) matches(attributes map[string]string) bool {
+	for k, v := range f {
+		if got, ok := attributes[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// searchCommand pulls through the backlog of a subscription and prints the
+// messages with the given attributes as JSON lines, in the format push
+// endpoints receive, with a summary on stderr. The matching messages are
+// acknowledged unless --no-ack is given, and every other message is released
+// for redelivery.
+func searchCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	projectID := flags.String("project", defaultProjectID(), "Project ID containing the subscription")
+	subscriptionID := flags.String("subscription", "", "Pull subscription to search the backlog of")
+	attributes := attributeFilter{}
+	flags.Var(attributes, "attr", "Attribute key=value the messages must have; repeat for several")
+	noAck := flags.Bool("no-ack", false, "Release the matching messages for redelivery too, rather than acknowledging them")
+	limit := flags.Int("limit", 100000, "Most messages to search")
+	flags.Parse(args)
+
+	if *projectID == "" || *subscriptionID == "" || len(attributes) == 0 {
+		return fmt.Errorf("search: --project, --subscription and --attr are required")
+	}
+
+	opts, err := clientOptions(ctx, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", *projectID)
+	}
+	client, err := pubsubapi.NewSubscriberClient(ctx, opts...)
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", *projectID)
+	}
+	defer client.Close()
+
+	name := subscriptionName(*projectID, *subscriptionID)
+	config, err := client.GetSubscription(ctx, &pubsubpb.GetSubscriptionRequest{Subscription: name})
+	if err != nil {
+		return apiErrorf(err, "Unable to get subscription %q for project %q", *subscriptionID, *projectID)
+	}
+	if config.GetPushConfig().GetPushEndpoint() != "" {
+		return fmt.Errorf("search: Subscription %q is a push subscription, which can't be pulled from", *subscriptionID)
+	}
+	if config.GetDeadLetterPolicy() != nil {
+		fmt.Fprintf(os.Stderr, "Warning: searching subscription %q counts as a delivery attempt of every message towards its dead letter policy\n", *subscriptionID)
+	}
+
+	debugf("Searching subscription %q for messages with %s", *subscriptionID, attributes)
+	searched := 0
+	var matched []string
+	encoder := json.NewEncoder(os.Stdout)
+	held, err := pullBacklog(ctx, client, name, *limit, backlogLease, func(m *pubsubpb.ReceivedMessage) {
+		searched++
+		message := m.GetMessage()
+		if !attributes.matches(message.GetAttributes()) {
+			return
+		}
+		matched = append(matched, m.GetAckId())
+		encoder.Encode(newPushMessage(message))
+	})
+	if err != nil {
+		releaseMessages(ctx, client, name, held)
+		return apiErrorf(err, "Unable to pull from subscription %q for project %q", *subscriptionID, *projectID)
+	}
+
+	release := held
+	if !*noAck && len(matched) > 0 {
+		acked := make(map[string]bool)
+		for _, ackID := range matched {
+			acked[ackID] = true
+		}
+		release = nil
+		for _, ackID := range held {
+			if !acked[ackID] {
+				release = append(release, ackID)
+			}
+		}
+		if err := acknowledgeMessages(ctx, client, name, matched); err != nil {
+			releaseMessages(ctx, client, name, release)
+			return apiErrorf(err, "Unable to acknowledge messages of subscription %q for project %q", *subscriptionID, *projectID)
+		}
+	}
+	if err := releaseMessages(ctx, client, name, release); err != nil {
+		return apiErrorf(err, "Unable to release messages of subscription %q for project %q", *subscriptionID, *projectID)
+	}
+
+	// The matching messages go to stdout, so the summary goes to stderr.
+	fmt.Fprintf(os.Stderr, "Found %d of %d messages in subscription %q\n", len(matched), searched, *subscriptionID)
+	return nil
+}
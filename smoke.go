@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// smokeRunAttribute marks smoke test messages with the run that published
+// them, so that they can be told apart from other messages.
+const smokeRunAttribute = "pubsubc-smoke-run"
+
+// smokeCommand publishes a message to every topic the config declares and
+// checks that each of its pull subscriptions receives it, as a quick end to
+// end test of an environment. Push subscriptions are skipped.
+func smokeCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("smoke", flag.ExitOnError)
+	junitFile := flags.String("junit", "", "Write a JUnit XML report with a test case per roundtrip to this file")
+	timeout := flags.Duration("timeout", 30*time.Second, "How long each subscription has to receive the message")
+	flags.Parse(args)
+
+	config, err := loadConfig(*configFile, *projectsFile, nameData(*branch, *buildID, *nameUser, runID))
+	if err != nil {
+		return err
+	}
+
+	var cases []testCase
+	var failures []string
+	for _, project := range config.Projects {
+		client, err := newClient(ctx, project.ID, project.EmulatorHost)
+		if err != nil {
+			return apiErrorf(err, "Unable to create client to project %q", project.ID)
+		}
+		for _, topic := range project.Topics {
+			for _, c := range smokeTopic(ctx, client, project.ID, topic, *timeout) {
+				if c.Failure != "" {
+					failures = append(failures, fmt.Sprintf("%s: %s", c.Name, c.Failure))
+				}
+				cases = append(cases, c)
+			}
+		}
+		client.Close()
+	}
+
+	if len(failures) > 0 {
+		err = ErrNotDelivered.wrapf("%d of %d smoke tests failed:\n  %s", len(failures), len(cases), strings.Join(failures, "\n  "))
+	}
+	return reportCases(*junitFile, "pubsubc smoke", cases, err)
+}
+
+// smokeTopic publishes a message to the topic and returns a test case for its
+// delivery to each of the topic's subscriptions.
+func smokeTopic(ctx context.Context, client *pubsub.Client, projectID string, topic *Topic, timeout time.Duration) []testCase {
+	var cases []testCase
+	var pull []*Subscription
+	for _, subscription := range topic.Subscriptions {
+		if subscription.PushEndpoint != "" {
+			cases = append(cases, testCase{
+				Class:   projectID,
+				Name:    subscriptionName(projectID, subscription.ID),
+				Skipped: "push subscription",
+			})
+			continue
+		}
+		pull = append(pull, subscription)
+	}
+	if len(pull) == 0 {
+		return cases
+	}
+
+	debugf("Smoke testing topic %q on project %q", topic.ID, projectID)
+	start := time.Now()
+	t := client.Topic(topic.ID)
+	t.EnableMessageOrdering = true
+	result := t.Publish(ctx, &pubsub.Message{
+		Data:        []byte("pubsubc smoke test"),
+		Attributes:  map[string]string{smokeRunAttribute: runID},
+		OrderingKey: smokeRunAttribute,
+	})
+	id, err := result.Get(ctx)
+	t.Stop()
+
+	for _, subscription := range pull {
+		c := testCase{Class: projectID, Name: subscriptionName(projectID, subscription.ID)}
+		if err != nil {
+			c.Failure = fmt.Sprintf("unable to publish to topic %q: %s", topic.ID, err)
+			c.Elapsed = time.Since(start)
+			cases = append(cases, c)
+			continue
+		}
+
+		receiveStart := time.Now()
+		var mu sync.Mutex
+		received := false
+		receiveErr := receiveHeld(ctx, client.Subscription(subscription.ID), timeout, func(m *pubsub.Message) (bool, bool) {
+			if m.ID != id {
+				return false, false
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			received = true
+			return true, true
+		})
+		c.Elapsed = time.Since(receiveStart)
+		switch {
+		case receiveErr != nil && !errors.Is(receiveErr, context.Canceled) && !errors.Is(receiveErr, context.DeadlineExceeded):
+			c.Failure = fmt.Sprintf("unable to receive: %s", receiveErr)
+		case !received:
+			c.Failure = fmt.Sprintf("message not received within %s", timeout)
+		}
+		cases = append(cases, c)
+	}
+	return cases
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// soakSample is the state of a soak test at the end of an interval. Counts are
+// totals since the start.
+type soakSample struct {
+	Elapsed       time.Duration `json:"-"`
+	ElapsedMS     int64         `json:"elapsed_ms"`
+	Published     int64         `json:"published"`
+	PublishErrors int64         `json:"publish_errors"`
+	Received      int64         `json:"received"`
+	Redeliveries  int64         `json:"redeliveries"`
+	ReceiveErrors int64         `json:"receive_errors"`
+	HeapBytes     uint64        `json:"heap_bytes"`
+	Goroutines    int           `json:"goroutines"`
+}
+
+func (s soakSample) String() string {
+	return fmt.Sprintf("elapsed=%s published=%d publish_errors=%d received=%d redeliveries=%d receive_errors=%d heap=%.1fMB goroutines=%d",
+		s.Elapsed.Round(time.Second), s.Published, s.PublishErrors, s.Received, s.Redeliveries, s.ReceiveErrors, float64(s.HeapBytes)/(1<<20), s.Goroutines)
+}
+
+// soakRecentIDs is how many of the most recently received message IDs a soak
+// test remembers to spot redeliveries, which keeps its own memory flat.
+const soakRecentIDs = 100000
+
+// recentIDs remembers up to a fixed number of the most recently added IDs.
+type recentIDs struct {
+	ids  []string
+	next int
+	seen map[string]bool
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{ids: make([]string, 0, size), seen: make(map[string]bool, size)}
+}
+
+// add records the ID, forgetting the oldest one if full, and reports whether
+// it was already recorded.
+func (r *recentIDs) add(id string) bool {
+	if r.seen[id] {
+		return true
+	}
+	if len(r.ids) < cap(r.ids) {
+		r.ids = append(r.ids, id)
+	} else {
+		delete(r.seen, r.ids[r.next])
+		r.ids[r.next] = id
+		r.next = (r.next + 1) % len(r.ids)
+	}
+	r.seen[id] = true
+	return false
+}
+
+// parseRate parses a rate such as "200/s", "10/m" or "50", which is per second,
+// into messages per second.
+func parseRate(s string) (float64, error) {
+	count, unit := s, "s"
+	if i := strings.Index(s, "/"); i >= 0 {
+		count, unit = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Invalid rate %q: expected a positive number of messages per s, m or h", s)
+	}
+	switch unit {
+	case "s":
+		return n, nil
+	case "m":
+		return n / 60, nil
+	case "h":
+		return n / 3600, nil
+	default:
+		return 0, fmt.Errorf("Invalid rate %q: expected a positive number of messages per s, m or h", s)
+	}
+}
+
+// benchSoak publishes at a steady rate for a long time, optionally receiving
+// as well, and reports errors, redeliveries and the memory and goroutines of
+// pubsubc's clients at every interval, to reproduce slow leaks.
+func benchSoak(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("bench soak", flag.ExitOnError)
+	projectID := flags.String("project", defaultProjectID(), "Project ID containing the topic and subscription")
+	topicID := flags.String("topic", "", "Topic to publish to")
+	subscriptionID := flags.String("subscription", "", "Subscription on the topic to receive from, with --consume")
+	duration := flags.Duration("duration", time.Hour, "How long to run for")
+	rateFlag := flags.String("publish-rate", "100/s", "Messages to publish per second, minute or hour, such as 200/s")
+	consume := flags.Bool("consume", false, "Receive and acknowledge messages from --subscription while publishing")
+	interval := flags.Duration("interval", time.Minute, "How often to report")
+	size := flags.Int("size", 1024, "Message payload size in bytes")
+	reportFile := flags.String("report", "", "Write the report of every interval to this file as JSON")
+	publishSettings := addPublishFlags(flags)
+	receiveSettings := addReceiveFlags(flags)
+	flags.Parse(args)
+
+	if *projectID == "" || *topicID == "" || (*consume && *subscriptionID == "") {
+		return fmt.Errorf("bench soak: --project and --topic are required, and --subscription with --consume")
+	}
+	rate, err := parseRate(*rateFlag)
+	if err != nil {
+		return fmt.Errorf("bench soak: %s", err)
+	}
+	period := time.Duration(float64(time.Second) / rate)
+	if period <= 0 {
+		return fmt.Errorf("bench soak: --publish-rate %q is too high to publish at", *rateFlag)
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("bench soak: --interval must be positive")
+	}
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", *projectID)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	var published, publishErrors, received, redeliveries, receiveErrors int64
+	var wg sync.WaitGroup
+	if *consume {
+		subscription := client.Subscription(*subscriptionID)
+		receiveSettings.apply(subscription)
+
+		// A redelivery is spotted by its delivery attempt if the subscription
+		// dead letters, or else by its ID having been received recently.
+		var mu sync.Mutex
+		recent := newRecentIDs(soakRecentIDs)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Receive returns on errors it can't retry, so keep receiving
+			// until the soak is over.
+			for ctx.Err() == nil {
+				err := subscription.Receive(ctx, func(_ context.Context, m *pubsub.Message) {
+					m.Ack()
+					atomic.AddInt64(&received, 1)
+					if m.DeliveryAttempt != nil {
+						if *m.DeliveryAttempt > 1 {
+							atomic.AddInt64(&redeliveries, 1)
+						}
+						return
+					}
+					mu.Lock()
+					if recent.add(m.ID) {
+						atomic.AddInt64(&redeliveries, 1)
+					}
+					mu.Unlock()
+				})
+				if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+					atomic.AddInt64(&receiveErrors, 1)
+					debugf("  Receive failed: %s", err)
+					time.Sleep(time.Second)
+				}
+			}
+		}()
+	}
+
+	topic := client.Topic(*topicID)
+	publishSettings.apply(topic)
+	defer topic.Stop()
+
+	var samples []soakSample
+	sample := func(elapsed time.Duration) soakSample {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		s := soakSample{
+			Elapsed:       elapsed,
+			ElapsedMS:     elapsed.Milliseconds(),
+			Published:     atomic.LoadInt64(&published),
+			PublishErrors: atomic.LoadInt64(&publishErrors),
+			Received:      atomic.LoadInt64(&received),
+			Redeliveries:  atomic.LoadInt64(&redeliveries),
+			ReceiveErrors: atomic.LoadInt64(&receiveErrors),
+			HeapBytes:     mem.HeapAlloc,
+			Goroutines:    runtime.NumGoroutine(),
+		}
+		samples = append(samples, s)
+		fmt.Println(s)
+		return s
+	}
+
+	debugf("Soaking topic %q on project %q at %.1f msg/s for %s", *topicID, *projectID, rate, *duration)
+	payload := make([]byte, *size)
+	publish := time.NewTicker(period)
+	defer publish.Stop()
+	report := time.NewTicker(*interval)
+	defer report.Stop()
+	start := time.Now()
+	for ctx.Err() == nil {
+		select {
+		case <-publish.C:
+			result := topic.Publish(ctx, &pubsub.Message{Data: payload})
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := result.Get(ctx); err != nil {
+					if ctx.Err() == nil {
+						atomic.AddInt64(&publishErrors, 1)
+						debugf("  Publish failed: %s", err)
+					}
+					return
+				}
+				atomic.AddInt64(&published, 1)
+			}()
+		case <-report.C:
+			sample(time.Since(start))
+		case <-ctx.Done():
+		}
+	}
+	wg.Wait()
+	last := sample(time.Since(start))
+
+	if *reportFile != "" {
+		if err := writeReport(*reportFile, samples); err != nil {
+			return err
+		}
+	}
+	if last.PublishErrors > 0 || last.ReceiveErrors > 0 {
+		return fmt.Errorf("bench soak: %d publish errors and %d receive errors", last.PublishErrors, last.ReceiveErrors)
+	}
+	return nil
+}
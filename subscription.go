@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// subscriptionCommand runs one of the subscription subcommands.
+func subscriptionCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Expected a subscription command: set-push or set-pull")
+	}
+
+	switch args[0] {
+	case "set-push", "set-pull":
+		return setPushEndpoint(ctx, args[0], args[1:])
+	default:
+		return fmt.Errorf("Unknown subscription command %q", args[0])
+	}
+}
+
+// setPushEndpoint turns a subscription into a push subscription to an
+// endpoint, or with set-pull into a pull subscription, in place.
+func setPushEndpoint(ctx context.Context, command string, args []string) error {
+	flags := flag.NewFlagSet("subscription "+command, flag.ExitOnError)
+	projectID := flags.String("project", defaultProjectID(), "Project ID containing the subscription")
+	subscriptionID := flags.String("subscription", "", "Subscription to update")
+	var endpoint, serviceAccount, audience *string
+	if command == "set-push" {
+		endpoint = flags.String("endpoint", "", "URL to push messages to")
+		serviceAccount = flags.String("service-account", "", "Service account email to authenticate push requests as, with an OIDC token")
+		audience = flags.String("audience", "", "Audience of the OIDC token, defaulting to the endpoint, with --service-account")
+	}
+	flags.Parse(args)
+
+	if *projectID == "" || *subscriptionID == "" {
+		return fmt.Errorf("subscription %s: --project and --subscription are required", command)
+	}
+	pushConfig := pubsub.PushConfig{}
+	if endpoint != nil {
+		if *endpoint == "" {
+			return fmt.Errorf("subscription set-push: --endpoint is required")
+		}
+		var auth *PushAuth
+		if *serviceAccount != "" {
+			auth = &PushAuth{ServiceAccount: *serviceAccount, Audience: *audience}
+		} else if *audience != "" {
+			return fmt.Errorf("subscription set-push: --audience needs --service-account")
+		}
+		pushConfig = newPushConfig(*endpoint, auth)
+	}
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", *projectID)
+	}
+	defer client.Close()
+
+	if pushConfig.Endpoint != "" {
+		debugf("Pushing subscription %q to %q", *subscriptionID, pushConfig.Endpoint)
+	} else {
+		debugf("Making subscription %q a pull subscription", *subscriptionID)
+	}
+	done := track("update", subscriptionName(*projectID, *subscriptionID))
+	_, err = client.Subscription(*subscriptionID).Update(ctx, pubsub.SubscriptionConfigToUpdate{PushConfig: &pushConfig})
+	done(err)
+	if status.Code(err) == codes.InvalidArgument && pushConfig.Endpoint != "" {
+		return ErrBadPushEndpoint.wrapf("Unable to update subscription %q for project %q to push endpoint %q: %s", *subscriptionID, *projectID, pushConfig.Endpoint, err)
+	}
+	if err != nil {
+		return apiErrorf(err, "Unable to update subscription %q for project %q", *subscriptionID, *projectID)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Well-known CI environment variables holding build metadata, in order of
+// preference.
+var (
+	branchEnvs  = []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME", "CIRCLE_BRANCH", "BUILDKITE_BRANCH", "BRANCH_NAME", "GIT_BRANCH"}
+	buildIDEnvs = []string{"GITHUB_RUN_ID", "CI_PIPELINE_ID", "CIRCLE_BUILD_NUM", "BUILDKITE_BUILD_NUMBER", "BUILD_ID", "BUILD_NUMBER"}
+	userEnvs    = []string{"GITHUB_ACTOR", "GITLAB_USER_LOGIN", "CIRCLE_USERNAME", "BUILDKITE_BUILD_CREATOR", "USER", "USERNAME"}
+)
+
+// invalidNameChars matches the characters that can't appear in resource IDs.
+var invalidNameChars = regexp.MustCompile(`[^A-Za-z0-9\-_.~+%]`)
+
+// firstEnv returns the first of the environment variables that is set.
+func firstEnv(names []string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// nameData returns the values available to name templates, taking each from
+// its flag or else from the CI environment, along with the run ID. Values are
+// made safe for use in resource IDs, so a branch of "feature/x" becomes
+// "feature-x". Values that aren't set are left out, so that templates using
+// them fail to expand.
+func nameData(branch, buildID, user, runID string) map[string]string {
+	data := make(map[string]string)
+	set := func(key, value string, envs []string) {
+		if value == "" {
+			value = firstEnv(envs)
+		}
+		if value != "" {
+			data[key] = invalidNameChars.ReplaceAllString(value, "-")
+		}
+	}
+	set("Branch", branch, branchEnvs)
+	set("BuildID", buildID, buildIDEnvs)
+	set("User", user, userEnvs)
+	set("RunID", runID, nil)
+	return data
+}
+
+// expandName executes name as a template with data.
+func expandName(name string, data map[string]string) (string, error) {
+	if !strings.Contains(name, "{{") {
+		return name, nil
+	}
+
+	t, err := template.New("name").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return "", ErrConfigSyntax.wrapf("Unable to parse name template %q: %s", name, err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", ErrConfigSyntax.wrapf("Unable to expand name template %q: %s", name, err)
+	}
+	return b.String(), nil
+}
+
+// expandNames expands the templates in the project, topic and subscription IDs
+// and dead letter topic names of the config, after the defaults have been
+// applied. Projects with a count are replaced by that many copies, each with
+// its index as {{.Index}}.
+func (c *Config) expandNames(data map[string]string) error {
+	var projects []*Project
+	for _, project := range c.Projects {
+		if project.Count == 0 {
+			if project.Start != nil {
+				return ErrConfigSyntax.wrapf("Project %q has a start but no count", project.ID)
+			}
+			if err := project.expandNames(data); err != nil {
+				return err
+			}
+			projects = append(projects, project)
+			continue
+		}
+		if project.Count < 0 {
+			return ErrConfigSyntax.wrapf("Project %q has a negative count %d", project.ID, project.Count)
+		}
+
+		start := 1
+		if project.Start != nil {
+			start = *project.Start
+		}
+		indexed := make(map[string]string, len(data)+1)
+		for k, v := range data {
+			indexed[k] = v
+		}
+		for i := start; i < start+project.Count; i++ {
+			indexed["Index"] = strconv.Itoa(i)
+			copied := project.clone()
+			if err := copied.expandNames(indexed); err != nil {
+				return err
+			}
+			projects = append(projects, copied)
+		}
+	}
+	c.Projects = projects
+	return nil
+}
+
+// expandNames expands the templates in the names of the project.
+func (p *Project) expandNames(data map[string]string) error {
+	var err error
+	expand := func(name *string) {
+		if err == nil {
+			*name, err = expandName(*name, data)
+		}
+	}
+
+	expand(&p.ID)
+	for _, topic := range p.Topics {
+		expand(&topic.ID)
+		expand(&topic.Defaults.DeadLetterTopic)
+		if topic.Schema != nil {
+			expand(&topic.Schema.ID)
+		}
+		for _, subscription := range topic.Subscriptions {
+			expand(&subscription.ID)
+			expand(&subscription.DeadLetterTopic)
+		}
+	}
+	return err
+}
+
+// clone returns a copy of a project template without its count, with copies of
+// its topics and subscriptions so that they can be named independently.
+func (p *Project) clone() *Project {
+	copied := *p
+	copied.Count = 0
+	copied.Start = nil
+	copied.Topics = nil
+	for _, topic := range p.Topics {
+		t := *topic
+		if topic.Schema != nil {
+			schema := *topic.Schema
+			t.Schema = &schema
+		}
+		t.Subscriptions = nil
+		for _, subscription := range topic.Subscriptions {
+			s := *subscription
+			t.Subscriptions = append(t.Subscriptions, &s)
+		}
+		copied.Topics = append(copied.Topics, &t)
+	}
+	return &copied
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+)
+
+// schemaEncodings are the schema encodings by the names --encoding accepts.
+var schemaEncodings = map[string]pubsub.SchemaEncoding{
+	"binary": pubsub.EncodingBinary,
+	"json":   pubsub.EncodingJSON,
+}
+
+// topicCommand runs one of the topic subcommands.
+func topicCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Expected a topic command: update")
+	}
+
+	switch args[0] {
+	case "update":
+		return updateTopic(ctx, args[1:])
+	default:
+		return fmt.Errorf("Unknown topic command %q", args[0])
+	}
+}
+
+// updateTopic attaches a schema to an existing topic, keeping its
+// subscriptions.
+func updateTopic(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("topic update", flag.ExitOnError)
+	projectID := flags.String("project", defaultProjectID(), "Project ID containing the topic")
+	topicID := flags.String("topic", "", "Topic to update")
+	schema := flags.String("schema", "", "ID of the schema in the project, or full name of a schema, to validate messages against")
+	encoding := flags.String("encoding", "binary", "Encoding of the messages validated against the schema: binary or json")
+	flags.Parse(args)
+
+	if *projectID == "" || *topicID == "" || *schema == "" {
+		return fmt.Errorf("topic update: --project, --topic and --schema are required")
+	}
+	schemaEncoding, ok := schemaEncodings[*encoding]
+	if !ok {
+		return fmt.Errorf("topic update: --encoding must be binary or json, not %q", *encoding)
+	}
+	name := *schema
+	if !strings.Contains(name, "/") {
+		name = schemaName(*projectID, name)
+	}
+
+	client, err := newClient(ctx, *projectID, "")
+	if err != nil {
+		return apiErrorf(err, "Unable to create client to project %q", *projectID)
+	}
+	defer client.Close()
+
+	debugf("Attaching schema %q to topic %q", name, *topicID)
+	done := track("update", topicName(*projectID, *topicID))
+	_, err = client.Topic(*topicID).Update(ctx, pubsub.TopicConfigToUpdate{
+		SchemaSettings: &pubsub.SchemaSettings{Schema: name, Encoding: schemaEncoding},
+	})
+	done(err)
+	if err != nil {
+		return apiErrorf(err, "Unable to update topic %q for project %q", *topicID, *projectID)
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// resourceIDPattern matches valid topic and subscription IDs: 3 to 255
+// letters, numbers, dashes, underscores, periods, tildes, plus or percent
+// signs, starting with a letter.
+var resourceIDPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9\-_.~+%]{2,254}$`)
+
+// validate checks the config for resources that would conflict when created,
+// so that they are reported together before any API call is made.
+func (c *Config) validate() error {
+	var problems []string
+	for _, project := range c.Projects {
+		problems = append(problems, project.invalidNames()...)
+		problems = append(problems, project.invalidPushAuth()...)
+	}
+	for _, project := range c.mergedProjects() {
+		problems = append(problems, project.conflicts()...)
+		problems = append(problems, project.invalidSchemas()...)
+	}
+	problems = append(problems, c.invalidDeadLetterTopics()...)
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return ErrConfigInvalid.wrapf("Invalid config:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// mergedProjects returns the projects of the config with the definitions of
+// the same project on the same emulator combined, as PUBSUB_PROJECT variables,
+// the config file and the projects file can each define a project.
+func (c *Config) mergedProjects() []*Project {
+	var merged []*Project
+	byKey := make(map[string]*Project)
+	for _, project := range c.Projects {
+		key := project.EmulatorHost + " " + project.ID
+		if m, ok := byKey[key]; ok {
+			m.Topics = append(m.Topics, project.Topics...)
+			continue
+		}
+		m := &Project{ID: project.ID, EmulatorHost: project.EmulatorHost, Topics: append([]*Topic(nil), project.Topics...)}
+		byKey[key] = m
+		merged = append(merged, m)
+	}
+	return merged
+}
+
+// conflicts returns a description of every duplicate topic or subscription ID
+// in the project, including those of the dead letter topics and subscriptions
+// that will be created.
+func (p *Project) conflicts() []string {
+	var conflicts []string
+
+	// Record where each ID comes from so conflicts can name both sides.
+	topics := make(map[string]string)
+	subscriptions := make(map[string]string)
+	add := func(kind string, seen map[string]string, id, source string) {
+		previous, ok := seen[id]
+		switch {
+		case !ok:
+			seen[id] = source
+		case previous == source:
+			conflicts = append(conflicts, fmt.Sprintf("project %q: %s %q is %s more than once", p.ID, kind, id, source))
+		default:
+			conflicts = append(conflicts, fmt.Sprintf("project %q: %s %q is %s and %s", p.ID, kind, id, previous, source))
+		}
+	}
+	addTopic := func(id, source string) { add("topic", topics, id, source) }
+	addSubscription := func(id, source string) { add("subscription", subscriptions, id, source) }
+
+	for _, topic := range p.Topics {
+		addTopic(topic.ID, "declared")
+	}
+	for _, topic := range p.Topics {
+		deadLetterTopic := false
+		for _, subscription := range topic.Subscriptions {
+			addSubscription(subscription.ID, fmt.Sprintf("declared on topic %q", topic.ID))
+			if subscription.ownDeadLetters() {
+				deadLetterTopic = true
+			}
+		}
+		if deadLetterTopic {
+			addTopic(topic.deadLetterTopicID(), fmt.Sprintf("the dead letter topic of topic %q", topic.ID))
+		}
+	}
+	for _, topic := range p.Topics {
+		for _, subscription := range topic.Subscriptions {
+			if subscription.ownDeadLetters() {
+				addSubscription(subscription.deadLetterSubscriptionID(), fmt.Sprintf("the dead letter subscription of subscription %q", subscription.ID))
+			}
+		}
+	}
+
+	return conflicts
+}
+
+// invalidName describes why id is not a valid resource ID, or returns an
+// empty string if it is.
+func invalidName(id string) string {
+	switch {
+	case strings.HasPrefix(id, "goog"):
+		return `must not start with "goog"`
+	case len(id) < 3 || len(id) > 255:
+		return "must be between 3 and 255 characters long"
+	case !resourceIDPattern.MatchString(id):
+		return "must start with a letter and contain only letters, numbers and the characters - _ . ~ + %"
+	}
+	return ""
+}
+
+// invalidNames returns a description of every topic and subscription ID in the
+// project, including those of dead letter resources, that breaks the PubSub
+// naming rules.
+func (p *Project) invalidNames() []string {
+	var problems []string
+	check := func(kind, id string) {
+		if problem := invalidName(id); problem != "" {
+			problems = append(problems, fmt.Sprintf("project %q: %s %q %s", p.ID, kind, id, problem))
+		}
+	}
+
+	for _, topic := range p.Topics {
+		check("topic", topic.ID)
+		deadLetterTopic := false
+		for _, subscription := range topic.Subscriptions {
+			check("subscription", subscription.ID)
+			if subscription.ownDeadLetters() {
+				deadLetterTopic = true
+				check("dead letter subscription", subscription.deadLetterSubscriptionID())
+			}
+		}
+		if deadLetterTopic {
+			check("dead letter topic", topic.deadLetterTopicID())
+		}
+	}
+
+	return problems
+}
+
+// invalidPushAuth returns a description of every subscription in the project
+// whose push authentication can't be set up.
+func (p *Project) invalidPushAuth() []string {
+	var problems []string
+	for _, topic := range p.Topics {
+		for _, subscription := range topic.Subscriptions {
+			auth := subscription.PushAuth
+			switch {
+			case auth == nil:
+			case subscription.PushEndpoint == "":
+				problems = append(problems, fmt.Sprintf("project %q: subscription %q has push_auth but no push_endpoint", p.ID, subscription.ID))
+			case !strings.Contains(auth.ServiceAccount, "@"):
+				problems = append(problems, fmt.Sprintf("project %q: subscription %q push_auth service_account %q must be a service account email", p.ID, subscription.ID, auth.ServiceAccount))
+			}
+		}
+	}
+	return problems
+}
+
+// invalidSchemas returns a description of every topic schema in the project
+// that can't be created, or that has the ID of another with a different
+// definition.
+func (p *Project) invalidSchemas() []string {
+	var problems []string
+	schemas := make(map[string]*TopicSchema)
+	for _, topic := range p.Topics {
+		schema := topic.Schema
+		if schema == nil {
+			continue
+		}
+		if problem := invalidName(schema.ID); problem != "" {
+			problems = append(problems, fmt.Sprintf("project %q: topic %q schema %q %s", p.ID, topic.ID, schema.ID, problem))
+		}
+		if _, ok := schemaTypes[schema.Type]; !ok {
+			problems = append(problems, fmt.Sprintf("project %q: topic %q schema type %q must be avro or protobuf", p.ID, topic.ID, schema.Type))
+		}
+		if _, ok := schemaEncodings[schema.Encoding]; !ok && schema.Encoding != "" {
+			problems = append(problems, fmt.Sprintf("project %q: topic %q schema encoding %q must be binary or json", p.ID, topic.ID, schema.Encoding))
+		}
+		if strings.TrimSpace(schema.Definition) == "" {
+			problems = append(problems, fmt.Sprintf("project %q: topic %q schema %q has no definition", p.ID, topic.ID, schema.ID))
+		}
+		if previous, ok := schemas[schema.ID]; ok && (previous.Type != schema.Type || previous.Definition != schema.Definition) {
+			problems = append(problems, fmt.Sprintf("project %q: schema %q is declared with different definitions", p.ID, schema.ID))
+		}
+		schemas[schema.ID] = schema
+	}
+	return problems
+}
+
+// invalidDeadLetterTopics returns a description of every dead letter topic
+// that isn't a full topic resource name, or that names a topic the config
+// doesn't declare in a project that it does.
+func (c *Config) invalidDeadLetterTopics() []string {
+	declared := make(map[string]map[string]bool)
+	for _, project := range c.Projects {
+		topicIDs, _ := declaredResources(project)
+		if declared[project.ID] == nil {
+			declared[project.ID] = make(map[string]bool)
+		}
+		for _, topicID := range topicIDs {
+			declared[project.ID][topicID] = true
+		}
+	}
+
+	var problems []string
+	for _, project := range c.Projects {
+		for _, topic := range project.Topics {
+			for _, subscription := range topic.Subscriptions {
+				name := subscription.DeadLetterTopic
+				if !subscription.deadLetters() || name == "" {
+					continue
+				}
+				projectID, topicID, ok := splitTopicName(name)
+				switch {
+				case !ok || projectID == "":
+					problems = append(problems, fmt.Sprintf("project %q: subscription %q dead_letter_topic %q must be of the form projects/<project>/topics/<topic>", project.ID, subscription.ID, name))
+				case invalidName(topicID) != "":
+					problems = append(problems, fmt.Sprintf("project %q: subscription %q dead_letter_topic %q %s", project.ID, subscription.ID, name, invalidName(topicID)))
+				case declared[projectID] != nil && !declared[projectID][topicID]:
+					problems = append(problems, fmt.Sprintf("project %q: subscription %q dead_letter_topic %q is not declared in project %q", project.ID, subscription.ID, name, projectID))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// checkLimits checks the config creates no more than maxTopics topics and
+// maxSubscriptions subscriptions in total, including dead letter ones. A limit
+// of 0 is no limit. With --emulator-host, every emulator gets the same
+// resources, so the limits are for each of them.
+func (c *Config) checkLimits(maxTopics, maxSubscriptions int) error {
+	var topics, subscriptions int
+	for _, project := range c.Projects {
+		if len(emulatorHosts) > 0 && project.EmulatorHost != emulatorHosts[0] {
+			continue
+		}
+		topicIDs, subscriptionIDs := declaredResources(project)
+		topics += len(topicIDs)
+		subscriptions += len(subscriptionIDs)
+	}
+
+	if maxTopics > 0 && topics > maxTopics {
+		return ErrTooManyResources.wrapf("The config would create %d topics, more than --max-topics %d", topics, maxTopics)
+	}
+	if maxSubscriptions > 0 && subscriptions > maxSubscriptions {
+		return ErrTooManyResources.wrapf("The config would create %d subscriptions, more than --max-subscriptions %d", subscriptions, maxSubscriptions)
+	}
+	return nil
+}
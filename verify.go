@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/api/iterator"
+)
+
+// delivery is the outcome of checking that a subscription received a set of
+// messages.
+type delivery struct {
+	Subscription string
+	Expected     int
+	Received     int
+	Elapsed      time.Duration
+	// Skipped explains why the subscription wasn't checked, if it wasn't.
+	Skipped string
+}
+
+// heldMessages holds received messages until release nacks them all, so that
+// checking a subscription delivers each message to pubsubc once rather than
+// redelivering it straight away, which would count towards the delivery
+// attempts of its dead letter policy.
+type heldMessages struct {
+	mu       sync.Mutex
+	released bool
+	messages []*pubsub.Message
+}
+
+// hold holds the message, or nacks it if the messages were already released.
+func (h *heldMessages) hold(m *pubsub.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.released {
+		m.Nack()
+		return
+	}
+	h.messages = append(h.messages, m)
+}
+
+// release nacks every held message, making it available for redelivery.
+func (h *heldMessages) release() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.released = true
+	for _, m := range h.messages {
+		m.Nack()
+	}
+	h.messages = nil
+}
+
+// receiveHeld receives from the subscription for up to timeout, calling fn
+// with each message, until fn reports it is done. Messages fn doesn't ack are
+// held until then and released, so every message is delivered once. They are
+// released before receiving stops, as nacks sent after that are lost. Flow
+// control is off, so that held messages can't stop others being received.
+func receiveHeld(ctx context.Context, subscription *pubsub.Subscription, timeout time.Duration, fn func(m *pubsub.Message) (ack, done bool)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	held := &heldMessages{}
+	stop := func() {
+		held.release()
+		cancel()
+	}
+	timer := time.AfterFunc(timeout, stop)
+	defer timer.Stop()
+
+	subscription.ReceiveSettings.MaxOutstandingMessages = -1
+	subscription.ReceiveSettings.MaxOutstandingBytes = -1
+	return subscription.Receive(ctx, func(_ context.Context, m *pubsub.Message) {
+		ack, done := fn(m)
+		if ack {
+			m.Ack()
+		} else {
+			held.hold(m)
+		}
+		if done {
+			stop()
+		}
+	})
+}
+
+// verifyDelivery checks that every pull subscription of the topic receives the
+// messages with the given IDs within timeout. Messages are released rather
+// than acked, so they are still delivered to the subscription's consumers, and
+// each message is received once. Push subscriptions can't be received from,
+// so they are skipped.
+func verifyDelivery(ctx context.Context, client *pubsub.Client, topic *pubsub.Topic, ids map[string]bool, timeout time.Duration) ([]delivery, error) {
+	var deliveries []delivery
+	subscriptions := topic.Subscriptions(ctx)
+	for {
+		subscription, err := subscriptions.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, apiErrorf(err, "Unable to list subscriptions of topic %q", topic.ID())
+		}
+
+		d := delivery{Subscription: subscription.String(), Expected: len(ids)}
+		config, err := subscription.Config(ctx)
+		if err != nil {
+			return nil, apiErrorf(err, "Unable to get subscription %q", subscription.ID())
+		}
+		if config.PushConfig.Endpoint != "" {
+			d.Skipped = "push subscription"
+			deliveries = append(deliveries, d)
+			continue
+		}
+
+		debugf("  Verifying delivery of %d messages to subscription %q", len(ids), subscription.ID())
+		start := time.Now()
+		var mu sync.Mutex
+		seen := make(map[string]bool)
+		err = receiveHeld(ctx, subscription, timeout, func(m *pubsub.Message) (bool, bool) {
+			if !ids[m.ID] {
+				return false, false
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			seen[m.ID] = true
+			return false, len(seen) == len(ids)
+		})
+		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			return nil, apiErrorf(err, "Unable to receive from subscription %q", subscription.ID())
+		}
+		d.Received = len(seen)
+		d.Elapsed = time.Since(start)
+		deliveries = append(deliveries, d)
+	}
+
+	var problems []string
+	for _, d := range deliveries {
+		if d.Skipped == "" && d.Received < d.Expected {
+			problems = append(problems, fmt.Sprintf("%s received %d of %d messages", d.Subscription, d.Received, d.Expected))
+		}
+	}
+	if len(problems) > 0 {
+		return deliveries, ErrNotDelivered.wrapf("Messages were not delivered within %s:\n  %s", timeout, strings.Join(problems, "\n  "))
+	}
+	return deliveries, nil
+}
+
+// verifyCommand checks that every topic and subscription the config declares,
+// including dead letter ones, exists.
+func verifyCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	junitFile := flags.String("junit", "", "Write a JUnit XML report with a test case per resource to this file")
+	flags.Parse(args)
+
+	config, err := loadConfig(*configFile, *projectsFile, nameData(*branch, *buildID, *nameUser, runID))
+	if err != nil {
+		return err
+	}
+
+	var cases []testCase
+	var missing []string
+	for _, project := range config.Projects {
+		client, err := newClient(ctx, project.ID, project.EmulatorHost)
+		if err != nil {
+			return apiErrorf(err, "Unable to create client to project %q", project.ID)
+		}
+
+		check := func(name string, exists func(context.Context) (bool, error)) error {
+			start := time.Now()
+			ok, err := exists(ctx)
+			if err != nil {
+				return apiErrorf(err, "Unable to check %q", name)
+			}
+			c := testCase{Class: project.ID, Name: name, Elapsed: time.Since(start)}
+			if !ok {
+				c.Failure = "does not exist"
+				missing = append(missing, name)
+			}
+			cases = append(cases, c)
+			return nil
+		}
+		topicIDs, subscriptionIDs := declaredResources(project)
+		for _, topicID := range topicIDs {
+			if err := check(topicName(project.ID, topicID), client.Topic(topicID).Exists); err != nil {
+				client.Close()
+				return err
+			}
+		}
+		for _, subscriptionID := range subscriptionIDs {
+			if err := check(subscriptionName(project.ID, subscriptionID), client.Subscription(subscriptionID).Exists); err != nil {
+				client.Close()
+				return err
+			}
+		}
+		client.Close()
+	}
+
+	if len(missing) > 0 {
+		err = ErrMissing.wrapf("%d of %d declared resources are missing:\n  %s", len(missing), len(cases), strings.Join(missing, "\n  "))
+	}
+	return reportCases(*junitFile, "pubsubc verify", cases, err)
+}